@@ -24,10 +24,10 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/pkg/errors"
-	gorp "gopkg.in/gorp.v2"
 
 	"github.com/CovenantSQL/CovenantSQL/client"
 	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/config"
+	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/metrics"
 	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/model"
 	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/utils"
 	"github.com/CovenantSQL/CovenantSQL/route"
@@ -52,7 +52,24 @@ func applyToken(c *gin.Context) {
 		return
 	}
 
+	r := struct {
+		Token   string `json:"challenge_token" form:"challenge_token" binding:"required"`
+		Proof   string `json:"proof" form:"proof" binding:"required"`
+		Captcha string `json:"captcha_token" form:"captcha_token"`
+	}{}
+	if err := c.ShouldBind(&r); err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
 	developer := getDeveloperID(c)
+
+	if err := verifyFaucetGate(c, developer, r.Token, r.Proof, r.Captcha); err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusForbidden, err)
+		return
+	}
+
 	p, err := model.GetMainAccount(model.GetDB(c), developer)
 	if err != nil {
 		_ = c.Error(err)
@@ -62,6 +79,7 @@ func applyToken(c *gin.Context) {
 
 	err = model.CheckTokenApplyLimits(model.GetDB(c), developer, p.Account, userLimits, accountLimits)
 	if err != nil {
+		metrics.FaucetQuotaRejections.WithLabelValues("quota_exceeded").Inc()
 		_ = c.Error(err)
 		abortWithError(c, http.StatusInternalServerError, ErrTokenApplyLimitExceeded)
 		return
@@ -124,7 +142,10 @@ func showAllAccounts(c *gin.Context) {
 
 		keyData["account"] = req.Addr.String()
 
-		if err = rpc.RequestBP(route.MCCQueryAccountTokenBalance.String(), req, resp); err == nil {
+		bpStart := time.Now()
+		err = rpc.RequestBP(route.MCCQueryAccountTokenBalance.String(), req, resp)
+		metrics.ObserveBPRequest(c.Request.Context(), route.MCCQueryAccountTokenBalance.String(), err, time.Since(bpStart))
+		if err == nil {
 			keyData["balance"] = resp.Balance
 		} else {
 			err = nil
@@ -159,7 +180,10 @@ func getBalance(c *gin.Context) {
 		return
 	}
 
-	if err = rpc.RequestBP(route.MCCQueryAccountTokenBalance.String(), req, resp); err != nil {
+	bpStart := time.Now()
+	err = rpc.RequestBP(route.MCCQueryAccountTokenBalance.String(), req, resp)
+	metrics.ObserveBPRequest(c.Request.Context(), route.MCCQueryAccountTokenBalance.String(), err, time.Since(bpStart))
+	if err != nil {
 		_ = c.Error(err)
 		abortWithError(c, http.StatusInternalServerError, ErrSendETLSRPCFailed)
 		return
@@ -188,13 +212,22 @@ func setMainAccount(c *gin.Context) {
 		return
 	}
 
+	emit(developer, model.WebhookEventMainAccountChanged, "account.main_changed", gin.H{
+		"account": r.Account,
+	})
+
 	responseWithData(c, http.StatusOK, nil)
 
 	return
 }
 
 // ApplyTokenTask handles the token apply process.
-func ApplyTokenTask(ctx context.Context, cfg *config.Config, db *gorp.DbMap, t *model.Task) (r gin.H, err error) {
+func ApplyTokenTask(ctx context.Context, cfg *config.Config, db model.DB, t *model.Task) (r gin.H, err error) {
+	start := time.Now()
+	defer func() {
+		metrics.ObserveTask(ctx, string(model.TaskApplyToken), err, time.Since(start))
+	}()
+
 	args := struct {
 		Amount uint64 `json:"amount"`
 	}{}
@@ -216,7 +249,9 @@ func ApplyTokenTask(ctx context.Context, cfg *config.Config, db *gorp.DbMap, t *
 		return
 	}
 
+	_, transferSpan := metrics.StartSpan(ctx, "client.TransferToken")
 	txHash, err := client.TransferToken(accountAddr, args.Amount, types.Particle)
+	transferSpan.End()
 	if err != nil {
 		err = errors.Wrapf(err, "send transfer token rpc failed")
 		return
@@ -233,7 +268,10 @@ func ApplyTokenTask(ctx context.Context, cfg *config.Config, db *gorp.DbMap, t *
 	timeoutCtx, cancelCtx := context.WithTimeout(ctx, 3*time.Minute)
 	defer cancelCtx()
 
-	lastState, _ := waitForTxState(timeoutCtx, txHash)
+	waitCtx, waitSpan := metrics.StartSpan(timeoutCtx, "waitForTxState")
+	lastState, _ := waitForTxState(waitCtx, txHash)
+	waitSpan.End()
+
 	r = gin.H{
 		"id":      ar.ID,
 		"account": p.Account,
@@ -241,5 +279,12 @@ func ApplyTokenTask(ctx context.Context, cfg *config.Config, db *gorp.DbMap, t *
 		"state":   lastState.String(),
 	}
 
+	emit(t.Developer, model.WebhookEventTokenApplyStateChanged, "token_apply.state_changed", gin.H{
+		"id":      ar.ID,
+		"account": p.Account,
+		"tx":      txHash.String(),
+		"state":   lastState.String(),
+	})
+
 	return
 }
@@ -0,0 +1,177 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/auth"
+	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/model"
+)
+
+// ErrUnknownAuthProvider is returned when /auth/:provider is hit with a provider name that
+// isn't registered in config.Config.Auth.
+var ErrUnknownAuthProvider = errors.New("unknown auth provider")
+
+// ErrAuthCallbackFailed wraps any failure exchanging a provider's callback for an Identity.
+var ErrAuthCallbackFailed = errors.New("auth callback failed")
+
+// ErrInvalidOAuthState is returned when a callback's state parameter doesn't match the nonce
+// authLogin issued for this browser, which is how a login-CSRF attempt gets caught.
+var ErrInvalidOAuthState = errors.New("invalid oauth state")
+
+const sessionCookieName = "cql_session"
+const sessionCookieTTL = 30 * 24 * time.Hour
+
+const oauthStateCookieName = "cql_oauth_state"
+const oauthStateCookieTTL = 10 * time.Minute
+
+// sessionClaims is the JWT payload stored in the session cookie set after a successful login.
+type sessionClaims struct {
+	Developer int64 `json:"developer"`
+	jwt.StandardClaims
+}
+
+// authLogin redirects the browser to the named provider's LoginURL, starting a login. The
+// state embedded in that URL is a nonce minted here and stashed in a short-lived cookie, not
+// anything the client supplied - authCallback rejects any callback whose state doesn't match
+// it, which is what stops an attacker from starting their own flow and tricking a victim into
+// completing it under the attacker's identity (login CSRF).
+func authLogin(c *gin.Context) {
+	provider, err := getAuthRegistry(c).Get(c.Param("provider"))
+	if err != nil {
+		abortWithError(c, http.StatusNotFound, ErrUnknownAuthProvider)
+		return
+	}
+
+	state, err := newOAuthState()
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusInternalServerError, ErrAuthCallbackFailed)
+		return
+	}
+
+	c.SetCookie(oauthStateCookieName, state, int(oauthStateCookieTTL.Seconds()), "/", "", false, true)
+	c.Redirect(http.StatusFound, provider.LoginURL(state))
+}
+
+// authCallback completes a login by exchanging the provider's callback parameters for an
+// Identity, provisioning or linking the resulting model.Developer, and issuing a session
+// cookie for subsequent requests.
+func authCallback(c *gin.Context) {
+	provider, err := getAuthRegistry(c).Get(c.Param("provider"))
+	if err != nil {
+		abortWithError(c, http.StatusNotFound, ErrUnknownAuthProvider)
+		return
+	}
+
+	expectedState, stateErr := c.Cookie(oauthStateCookieName)
+	c.SetCookie(oauthStateCookieName, "", -1, "/", "", false, true)
+	if stateErr != nil || expectedState == "" || c.Query("state") != expectedState {
+		abortWithError(c, http.StatusUnauthorized, ErrInvalidOAuthState)
+		return
+	}
+
+	query := make(map[string]string, len(c.Request.URL.Query()))
+	for k := range c.Request.URL.Query() {
+		query[k] = c.Query(k)
+	}
+
+	// Kept separate from query, never merged: header-based providers (SSPI) read their trusted
+	// principal out of headers, since an upstream proxy forwards it as a header rather than a
+	// callback query parameter. Merging the two would let a caller-supplied query parameter of
+	// the same name shadow (i.e. forge) the real header.
+	headers := make(map[string]string, len(c.Request.Header))
+	for k := range c.Request.Header {
+		headers[k] = c.Request.Header.Get(k)
+	}
+
+	id, err := provider.Callback(c.Request.Context(), query, headers)
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusUnauthorized, ErrAuthCallbackFailed)
+		return
+	}
+
+	encryptedToken, err := auth.EncryptToken(getConfig(c).Auth.TokenEncryptionKey, id.RefreshToken)
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusInternalServerError, ErrAuthCallbackFailed)
+		return
+	}
+
+	d, err := model.LinkExternalIdentity(model.GetDB(c), id.Provider, id.Subject, id.Email, encryptedToken)
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusInternalServerError, ErrAuthCallbackFailed)
+		return
+	}
+
+	if err = issueSessionCookie(c, d.ID); err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusInternalServerError, ErrAuthCallbackFailed)
+		return
+	}
+
+	responseWithData(c, http.StatusOK, gin.H{
+		"developer": d.ID,
+	})
+}
+
+// issueSessionCookie signs a sessionClaims JWT for developer and sets it as the session
+// cookie, the same cookie getDeveloperID reads back on subsequent requests.
+func issueSessionCookie(c *gin.Context, developer int64) error {
+	now := time.Now()
+	claims := sessionClaims{
+		Developer: developer,
+		StandardClaims: jwt.StandardClaims{
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(sessionCookieTTL).Unix(),
+		},
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).
+		SignedString(getConfig(c).Auth.SessionSigningKey)
+	if err != nil {
+		return errors.Wrapf(err, "sign session token failed")
+	}
+
+	c.SetCookie(sessionCookieName, token, int(sessionCookieTTL.Seconds()), "/", "", false, true)
+	return nil
+}
+
+// getAuthRegistry returns the *auth.Registry built from config.Config.Auth.Providers at
+// server startup.
+func getAuthRegistry(c *gin.Context) *auth.Registry {
+	return c.MustGet("authRegistry").(*auth.Registry)
+}
+
+// newOAuthState returns a random, URL-safe nonce to use as an OAuth2/OIDC state parameter.
+func newOAuthState() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrapf(err, "generate oauth state failed")
+	}
+	return hex.EncodeToString(b), nil
+}
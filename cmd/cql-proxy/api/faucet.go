@@ -0,0 +1,100 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"encoding/hex"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/faucet"
+)
+
+// ErrFaucetChallengeFailed is returned when a fresh Challenge couldn't be issued.
+var ErrFaucetChallengeFailed = errors.New("issue faucet challenge failed")
+
+// ErrInvalidProof is returned when a token-apply request's PoW challenge/proof is missing,
+// expired, already redeemed, or doesn't solve the issued Challenge.
+var ErrInvalidProof = errors.New("invalid proof of work")
+
+// ErrCaptchaFailed is returned when config.Faucet.Captcha is enabled and the submitted
+// captcha token fails siteverify.
+var ErrCaptchaFailed = errors.New("captcha verification failed")
+
+// challengeStore holds outstanding PoW challenges, and difficultyController auto-adjusts the
+// difficulty of newly issued ones with the recent rate of GET /api/faucet/challenge calls.
+var (
+	challengeStore       = faucet.NewStore()
+	difficultyController = faucet.NewDifficultyController()
+)
+
+// getFaucetChallenge issues a PoW challenge for the calling developer, to be solved and
+// submitted back alongside an /api/account/apply_token request.
+func getFaucetChallenge(c *gin.Context) {
+	developer := getDeveloperID(c)
+
+	difficulty := difficultyController.Current()
+	challenge, token, err := challengeStore.Issue(developer, difficulty)
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusInternalServerError, ErrFaucetChallengeFailed)
+		return
+	}
+
+	responseWithData(c, http.StatusOK, gin.H{
+		"nonce":      hex.EncodeToString(challenge.Nonce[:]),
+		"difficulty": challenge.Difficulty,
+		"token":      token,
+	})
+}
+
+// verifyFaucetGate redeems the proof-of-work challenge identified by token and, if CAPTCHA is
+// configured, verifies captchaToken against its siteverify endpoint. It returns the error to
+// surface to the client, or nil once both checks (PoW always, CAPTCHA if configured) pass.
+func verifyFaucetGate(c *gin.Context, developer int64, token string, proof string, captchaToken string) error {
+	challenge, ok := challengeStore.Redeem(developer, token)
+	if !ok {
+		return ErrInvalidProof
+	}
+
+	proofBytes, err := hex.DecodeString(proof)
+	if err != nil || !challenge.Verify(developer, proofBytes) {
+		return ErrInvalidProof
+	}
+
+	cfg := getConfig(c)
+	if cfg == nil || cfg.Faucet == nil || cfg.Faucet.Captcha == nil || !cfg.Faucet.Captcha.Enabled {
+		return nil
+	}
+
+	verifier, err := faucet.NewCaptchaVerifier(cfg.Faucet.Captcha.Provider, cfg.Faucet.Captcha.Secret)
+	if err != nil {
+		return errors.Wrapf(err, "build captcha verifier failed")
+	}
+
+	ok, err = verifier.Verify(c.Request.Context(), captchaToken, c.ClientIP())
+	if err != nil {
+		return errors.Wrapf(err, "verify captcha failed")
+	}
+	if !ok {
+		return ErrCaptchaFailed
+	}
+
+	return nil
+}
@@ -0,0 +1,236 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/model"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+// ErrInvalidOrg is returned for a malformed org/member/acl request.
+var ErrInvalidOrg = errors.New("invalid org request")
+
+func listOrgs(c *gin.Context) {
+	orgs, err := model.GetOrgsForDeveloper(model.GetDB(c), getDeveloperID(c))
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusInternalServerError, ErrInvalidOrg)
+		return
+	}
+
+	responseWithData(c, http.StatusOK, gin.H{
+		"orgs": orgs,
+	})
+}
+
+func createOrg(c *gin.Context) {
+	r := struct {
+		Name string `json:"name" form:"name" binding:"required"`
+	}{}
+
+	if err := c.ShouldBind(&r); err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	developer := getDeveloperID(c)
+	db := model.GetDB(c)
+
+	o, err := model.AddOrg(db, r.Name, false, time.Now().Unix())
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusInternalServerError, ErrInvalidOrg)
+		return
+	}
+
+	if _, err = model.AddOrgMember(db, o.ID, developer, model.RoleOwner); err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusInternalServerError, ErrInvalidOrg)
+		return
+	}
+
+	responseWithData(c, http.StatusOK, gin.H{
+		"org": o,
+	})
+}
+
+func listOrgMembers(c *gin.Context) {
+	org, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, ErrInvalidOrg)
+		return
+	}
+
+	db := model.GetDB(c)
+	if err = model.CanActOnOrg(db, getDeveloperID(c), org, model.RoleViewer); err != nil {
+		abortWithError(c, http.StatusForbidden, model.ErrForbidden)
+		return
+	}
+
+	members, err := model.GetOrgMembers(db, org)
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusInternalServerError, ErrInvalidOrg)
+		return
+	}
+
+	responseWithData(c, http.StatusOK, gin.H{
+		"members": members,
+	})
+}
+
+func addOrgMember(c *gin.Context) {
+	org, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, ErrInvalidOrg)
+		return
+	}
+
+	r := struct {
+		Developer int64      `json:"developer" form:"developer" binding:"required"`
+		Role      model.Role `json:"role" form:"role" binding:"required"`
+	}{}
+
+	if err = c.ShouldBind(&r); err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	db := model.GetDB(c)
+	if err = model.CanActOnOrg(db, getDeveloperID(c), org, model.RoleAdmin); err != nil {
+		abortWithError(c, http.StatusForbidden, model.ErrForbidden)
+		return
+	}
+
+	m, err := model.AddOrgMember(db, org, r.Developer, r.Role)
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusInternalServerError, ErrInvalidOrg)
+		return
+	}
+
+	responseWithData(c, http.StatusOK, gin.H{
+		"member": m,
+	})
+}
+
+func removeOrgMember(c *gin.Context) {
+	org, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, ErrInvalidOrg)
+		return
+	}
+
+	target, err := strconv.ParseInt(c.Param("developer"), 10, 64)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, ErrInvalidOrg)
+		return
+	}
+
+	db := model.GetDB(c)
+	if err = model.CanActOnOrg(db, getDeveloperID(c), org, model.RoleAdmin); err != nil {
+		abortWithError(c, http.StatusForbidden, model.ErrForbidden)
+		return
+	}
+
+	if err = model.RemoveOrgMember(db, org, target); err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusInternalServerError, ErrInvalidOrg)
+		return
+	}
+
+	responseWithData(c, http.StatusOK, nil)
+}
+
+func listProjectACL(c *gin.Context) {
+	dbID := proto.DatabaseID(c.Param("id"))
+
+	db := model.GetDB(c)
+	if err := model.CanActOnProject(db, getDeveloperID(c), dbID, model.ActionManageACL); err != nil {
+		abortWithError(c, http.StatusForbidden, model.ErrForbidden)
+		return
+	}
+
+	p, err := model.GetProjectByID(db, dbID, getDeveloperID(c))
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusNotFound, ErrInvalidOrg)
+		return
+	}
+
+	acls, err := model.GetProjectACLs(db, p.ID)
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusInternalServerError, ErrInvalidOrg)
+		return
+	}
+
+	responseWithData(c, http.StatusOK, gin.H{
+		"acl": acls,
+	})
+}
+
+func setProjectACL(c *gin.Context) {
+	dbID := proto.DatabaseID(c.Param("id"))
+
+	r := struct {
+		Developer int64      `json:"developer" form:"developer" binding:"required"`
+		Role      model.Role `json:"role" form:"role" binding:"required"`
+	}{}
+
+	if err := c.ShouldBind(&r); err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	db := model.GetDB(c)
+	if err := model.CanActOnProject(db, getDeveloperID(c), dbID, model.ActionManageACL); err != nil {
+		abortWithError(c, http.StatusForbidden, model.ErrForbidden)
+		return
+	}
+
+	p, err := model.GetProjectByID(db, dbID, getDeveloperID(c))
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusNotFound, ErrInvalidOrg)
+		return
+	}
+
+	_, err = model.GetProjectACL(db, p.ID, r.Developer)
+	switch {
+	case err == nil:
+		err = model.SetProjectACLRole(db, p.ID, r.Developer, r.Role)
+	case errors.Cause(err) == sql.ErrNoRows:
+		_, err = model.AddProjectACL(db, p.ID, r.Developer, r.Role)
+	}
+
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusInternalServerError, ErrInvalidOrg)
+		return
+	}
+
+	responseWithData(c, http.StatusOK, nil)
+}
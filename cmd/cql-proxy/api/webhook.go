@@ -0,0 +1,142 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/model"
+	wh "github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/webhook"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+// ErrInvalidWebhook is returned for a malformed webhook create/update request.
+var ErrInvalidWebhook = errors.New("invalid webhook")
+
+// ErrWebhookNotFound is returned when a webhook id doesn't belong to the requesting developer.
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// dispatcher is set once at server startup and used to fan out project/account/task events as
+// they happen. It's nil-safe so handlers and tasks work the same in tests that don't wire it.
+var dispatcher *wh.Dispatcher
+
+// SetDispatcher wires the webhook dispatcher started alongside the rest of the server, and
+// hooks model.Project's creation/deletion callbacks into it.
+func SetDispatcher(d *wh.Dispatcher) {
+	dispatcher = d
+
+	model.OnProjectCreated = func(developer int64, p *model.Project) {
+		emit(developer, model.WebhookEventProjectCreated, "project.created", gin.H{
+			"database_id": p.DB,
+			"alias":       p.Alias,
+		})
+	}
+	model.OnProjectDeleted = func(developer int64, dbID proto.DatabaseID) {
+		emit(developer, model.WebhookEventProjectDeleted, "project.deleted", gin.H{
+			"database_id": dbID,
+		})
+	}
+}
+
+func emit(developer int64, event int64, name string, data interface{}) {
+	if dispatcher == nil {
+		return
+	}
+	dispatcher.Emit(developer, event, name, 1, data, time.Now().Unix())
+}
+
+type webhookRequest struct {
+	URL       string `json:"url" form:"url" binding:"required,url"`
+	Secret    string `json:"secret" form:"secret" binding:"required"`
+	EventMask int64  `json:"event_mask" form:"event_mask" binding:"required"`
+}
+
+func listWebhooks(c *gin.Context) {
+	hooks, err := model.GetWebhooks(model.GetDB(c), getDeveloperID(c))
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusInternalServerError, ErrInvalidWebhook)
+		return
+	}
+
+	responseWithData(c, http.StatusOK, gin.H{
+		"webhooks": hooks,
+	})
+}
+
+func addWebhook(c *gin.Context) {
+	var r webhookRequest
+	if err := c.ShouldBind(&r); err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	w, err := model.AddWebhook(model.GetDB(c), getDeveloperID(c), r.URL, r.Secret, r.EventMask, time.Now().Unix())
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusInternalServerError, ErrInvalidWebhook)
+		return
+	}
+
+	responseWithData(c, http.StatusOK, gin.H{
+		"webhook": w,
+	})
+}
+
+func updateWebhook(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, ErrWebhookNotFound)
+		return
+	}
+
+	var r webhookRequest
+	if err = c.ShouldBind(&r); err != nil {
+		abortWithError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	developer := getDeveloperID(c)
+	if err = model.UpdateWebhook(model.GetDB(c), developer, id, r.URL, r.Secret, r.EventMask); err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusInternalServerError, ErrInvalidWebhook)
+		return
+	}
+
+	responseWithData(c, http.StatusOK, nil)
+}
+
+func deleteWebhook(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, ErrWebhookNotFound)
+		return
+	}
+
+	if err = model.DeleteWebhook(model.GetDB(c), getDeveloperID(c), id); err != nil {
+		_ = c.Error(err)
+		abortWithError(c, http.StatusInternalServerError, ErrInvalidWebhook)
+		return
+	}
+
+	responseWithData(c, http.StatusOK, nil)
+}
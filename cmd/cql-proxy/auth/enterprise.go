@@ -0,0 +1,74 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// ErrProviderNotConfigured is returned by enterprise providers that were registered but never
+// given the metadata/server configuration they need to actually run.
+var ErrProviderNotConfigured = errors.New("auth: provider registered but not fully configured")
+
+// SAML 2.0 support was scoped out of this package: a real implementation needs XML-dsig
+// signature verification against IdP-supplied metadata, and a stub that always returned
+// ErrProviderNotConfigured was worse than not offering the provider name at all. Add
+// SAMLConfig/samlProvider back once that verification is implemented.
+
+// SSPIConfig describes a Windows-integrated-authentication (SSPI/Kerberos) provider, used by
+// enterprise deployments sitting behind a domain-joined reverse proxy that already performed
+// Negotiate/NTLM authentication and forwards the resolved principal.
+type SSPIConfig struct {
+	Name          string
+	TrustedHeader string // header the upstream proxy sets with the authenticated principal
+}
+
+type sspiProvider struct {
+	cfg SSPIConfig
+}
+
+// NewSSPIProvider returns an AuthProvider that trusts the principal forwarded by an upstream
+// SSPI-authenticating reverse proxy in cfg.TrustedHeader.
+func NewSSPIProvider(cfg SSPIConfig) AuthProvider {
+	return &sspiProvider{cfg: cfg}
+}
+
+func (p *sspiProvider) Name() string {
+	return p.cfg.Name
+}
+
+func (p *sspiProvider) LoginURL(state string) string {
+	// SSPI is resolved by the upstream proxy before the request ever reaches cql-proxy, so
+	// there's no redirect to issue - the callback route is hit directly.
+	return ""
+}
+
+func (p *sspiProvider) Callback(ctx context.Context, query map[string]string, headers map[string]string) (*Identity, error) {
+	principal, ok := headers[p.cfg.TrustedHeader]
+
+	if !ok || principal == "" {
+		return nil, ErrProviderNotConfigured
+	}
+
+	return &Identity{
+		Provider:    p.cfg.Name,
+		Subject:     principal,
+		DisplayName: principal,
+	}, nil
+}
@@ -0,0 +1,198 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/github"
+	"golang.org/x/oauth2/google"
+)
+
+// OAuth2Config describes one OAuth2-backed AuthProvider, as parsed out of config.Config's
+// auth: block.
+type OAuth2Config struct {
+	Name         string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+
+	// AllowedDomains restricts login to users whose Identity.Email domain is in this list.
+	// Empty means unrestricted.
+	AllowedDomains []string
+}
+
+// oauth2Provider is an AuthProvider backed by golang.org/x/oauth2, with a provider-specific
+// profile fetcher to turn the resulting token into an Identity.
+type oauth2Provider struct {
+	cfg           OAuth2Config
+	oauth         *oauth2.Config
+	fetchIdentity func(ctx context.Context, client *http.Client) (*Identity, error)
+}
+
+// NewGitHubProvider returns an AuthProvider that logs users in with their GitHub account.
+func NewGitHubProvider(cfg OAuth2Config) AuthProvider {
+	return &oauth2Provider{
+		cfg: cfg,
+		oauth: &oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Endpoint:     github.Endpoint,
+			Scopes:       []string{"read:user", "user:email"},
+		},
+		fetchIdentity: fetchGitHubIdentity,
+	}
+}
+
+// NewGoogleProvider returns an AuthProvider that logs users in with their Google account.
+func NewGoogleProvider(cfg OAuth2Config) AuthProvider {
+	return &oauth2Provider{
+		cfg: cfg,
+		oauth: &oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Endpoint:     google.Endpoint,
+			Scopes:       []string{"openid", "email", "profile"},
+		},
+		fetchIdentity: fetchGoogleIdentity,
+	}
+}
+
+func (p *oauth2Provider) Name() string {
+	return p.cfg.Name
+}
+
+func (p *oauth2Provider) LoginURL(state string) string {
+	return p.oauth.AuthCodeURL(state, oauth2.AccessTypeOffline)
+}
+
+func (p *oauth2Provider) Callback(ctx context.Context, query map[string]string, headers map[string]string) (id *Identity, err error) {
+	code, ok := query["code"]
+
+	if !ok || code == "" {
+		return nil, errors.New("auth: missing OAuth2 code in callback")
+	}
+
+	token, err := p.oauth.Exchange(ctx, code)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "exchange OAuth2 code failed")
+	}
+
+	client := p.oauth.Client(ctx, token)
+	id, err = p.fetchIdentity(ctx, client)
+
+	if err != nil {
+		return nil, err
+	}
+
+	id.Provider = p.cfg.Name
+	id.AccessToken = token.AccessToken
+	id.RefreshToken = token.RefreshToken
+
+	if err = CheckEmailDomain(id.Email, p.cfg.AllowedDomains); err != nil {
+		return nil, err
+	}
+
+	return id, nil
+}
+
+type githubUser struct {
+	ID    int64  `json:"id"`
+	Login string `json:"login"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+func fetchGitHubIdentity(ctx context.Context, client *http.Client) (*Identity, error) {
+	resp, err := client.Get("https://api.github.com/user")
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "fetch GitHub profile failed")
+	}
+
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "read GitHub profile response failed")
+	}
+
+	var u githubUser
+
+	if err = json.Unmarshal(body, &u); err != nil {
+		return nil, errors.Wrapf(err, "decode GitHub profile failed")
+	}
+
+	return &Identity{
+		Subject:     strconv.FormatInt(u.ID, 10),
+		Email:       u.Email,
+		DisplayName: displayName(u.Name, u.Login),
+	}, nil
+}
+
+type googleUser struct {
+	Sub           string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+func fetchGoogleIdentity(ctx context.Context, client *http.Client) (*Identity, error) {
+	resp, err := client.Get("https://openidconnect.googleapis.com/v1/userinfo")
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "fetch Google profile failed")
+	}
+
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "read Google profile response failed")
+	}
+
+	var u googleUser
+
+	if err = json.Unmarshal(body, &u); err != nil {
+		return nil, errors.Wrapf(err, "decode Google profile failed")
+	}
+
+	return &Identity{
+		Subject:     u.Sub,
+		Email:       u.Email,
+		DisplayName: displayName(u.Name, u.Email),
+	}, nil
+}
+
+func displayName(name, fallback string) string {
+	if name != "" {
+		return name
+	}
+
+	return fallback
+}
@@ -0,0 +1,124 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"context"
+
+	oidc "github.com/coreos/go-oidc"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+// OIDCConfig describes a generic OpenID Connect provider, for operators running their own
+// identity provider (Keycloak, Dex, Okta, etc.) rather than a named social login.
+type OIDCConfig struct {
+	Name         string
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+
+	AllowedDomains []string
+}
+
+// oidcProvider is an AuthProvider backed by a standard OpenID Connect discovery document.
+type oidcProvider struct {
+	cfg      OIDCConfig
+	oauth    *oauth2.Config
+	provider *oidc.Provider
+	verifier *oidc.IDTokenVerifier
+}
+
+// NewOIDCProvider discovers cfg.IssuerURL's OIDC configuration and returns an AuthProvider for
+// it.
+func NewOIDCProvider(ctx context.Context, cfg OIDCConfig) (AuthProvider, error) {
+	provider, err := oidc.NewProvider(ctx, cfg.IssuerURL)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "discover OIDC issuer %s failed", cfg.IssuerURL)
+	}
+
+	return &oidcProvider{
+		cfg: cfg,
+		oauth: &oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       []string{oidc.ScopeOpenID, "email", "profile"},
+		},
+		provider: provider,
+		verifier: provider.Verifier(&oidc.Config{ClientID: cfg.ClientID}),
+	}, nil
+}
+
+func (p *oidcProvider) Name() string {
+	return p.cfg.Name
+}
+
+func (p *oidcProvider) LoginURL(state string) string {
+	return p.oauth.AuthCodeURL(state)
+}
+
+func (p *oidcProvider) Callback(ctx context.Context, query map[string]string, headers map[string]string) (*Identity, error) {
+	code, ok := query["code"]
+
+	if !ok || code == "" {
+		return nil, errors.New("auth: missing OIDC code in callback")
+	}
+
+	token, err := p.oauth.Exchange(ctx, code)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "exchange OIDC code failed")
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+
+	if !ok {
+		return nil, errors.New("auth: OIDC token response missing id_token")
+	}
+
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "verify OIDC id_token failed")
+	}
+
+	var claims struct {
+		Email string `json:"email"`
+		Name  string `json:"name"`
+	}
+
+	if err = idToken.Claims(&claims); err != nil {
+		return nil, errors.Wrapf(err, "decode OIDC claims failed")
+	}
+
+	if err = CheckEmailDomain(claims.Email, p.cfg.AllowedDomains); err != nil {
+		return nil, err
+	}
+
+	return &Identity{
+		Provider:     p.cfg.Name,
+		Subject:      idToken.Subject,
+		Email:        claims.Email,
+		DisplayName:  displayName(claims.Name, claims.Email),
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+	}, nil
+}
@@ -0,0 +1,131 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package auth provides a pluggable external identity subsystem for cql-proxy: OAuth2, OIDC
+// and enterprise SSPI providers all implement the same AuthProvider interface so the gin
+// handlers and account-provisioning logic don't need to know which one is in use.
+package auth
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// ErrUnknownProvider is returned by Registry.Get for a provider name that was never
+// registered via config.
+var ErrUnknownProvider = errors.New("auth: unknown provider")
+
+// ErrEmailDomainNotAllowed indicates that an identity's email falls outside the provider's
+// configured AllowedDomains.
+var ErrEmailDomainNotAllowed = errors.New("auth: email domain not allowed")
+
+// Identity is the normalized result of a successful login, regardless of which provider
+// produced it.
+type Identity struct {
+	// Provider is the registered name of the AuthProvider that authenticated this identity.
+	Provider string
+
+	// Subject is the provider's stable, unique identifier for the account (e.g. the GitHub
+	// user ID or the OIDC "sub" claim).
+	Subject string
+
+	Email       string
+	DisplayName string
+
+	// RefreshToken is stored encrypted against the resulting model.Developer so cql-proxy can
+	// act on the user's behalf with the upstream provider later, if needed. AccessToken is
+	// returned to the caller but not persisted.
+	AccessToken  string
+	RefreshToken string
+}
+
+// AuthProvider is implemented by every pluggable identity backend: OAuth2 (GitHub, Google),
+// generic OIDC, and enterprise SSPI.
+type AuthProvider interface {
+	// Name is the provider key used in the /auth/:provider/login and /auth/:provider/callback
+	// routes, and in config.Config's auth.providers list.
+	Name() string
+
+	// LoginURL returns the URL to redirect the user's browser to in order to start a login,
+	// embedding state as an opaque anti-CSRF token to be echoed back to Callback.
+	LoginURL(state string) string
+
+	// Callback exchanges the provider's callback request data for a normalized Identity. query
+	// holds the callback request's URL query parameters (e.g. an OAuth2 "code"); headers holds
+	// its HTTP request headers. The two are kept separate, rather than merged into one map, so
+	// a header-trusting provider (SSPI) can never have its trusted value shadowed by a
+	// client-supplied query parameter of the same name.
+	Callback(ctx context.Context, query map[string]string, headers map[string]string) (*Identity, error)
+}
+
+// Registry holds every AuthProvider enabled via config.Config.Auth.Providers, keyed by name.
+type Registry struct {
+	providers map[string]AuthProvider
+}
+
+// NewRegistry builds a Registry from a set of configured providers.
+func NewRegistry(providers ...AuthProvider) *Registry {
+	r := &Registry{providers: make(map[string]AuthProvider, len(providers))}
+
+	for _, p := range providers {
+		r.providers[p.Name()] = p
+	}
+
+	return r
+}
+
+// Get returns the named provider, or ErrUnknownProvider if it isn't registered.
+func (r *Registry) Get(name string) (AuthProvider, error) {
+	p, ok := r.providers[name]
+
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+
+	return p, nil
+}
+
+// CheckEmailDomain returns ErrEmailDomainNotAllowed if allowed is non-empty and email's domain
+// isn't in it. An empty allowed list permits every domain.
+func CheckEmailDomain(email string, allowed []string) error {
+	if len(allowed) == 0 {
+		return nil
+	}
+
+	at := -1
+
+	for i := len(email) - 1; i >= 0; i-- {
+		if email[i] == '@' {
+			at = i
+			break
+		}
+	}
+
+	if at < 0 {
+		return ErrEmailDomainNotAllowed
+	}
+
+	domain := email[at+1:]
+
+	for _, d := range allowed {
+		if d == domain {
+			return nil
+		}
+	}
+
+	return ErrEmailDomainNotAllowed
+}
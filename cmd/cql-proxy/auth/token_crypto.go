@@ -0,0 +1,95 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// EncryptToken encrypts a provider refresh token with AES-GCM under key (config.Config.Auth's
+// 32-byte TokenEncryptionKey) before it's stored via model.LinkExternalIdentity, so a
+// compromised database dump doesn't directly leak usable upstream credentials.
+func EncryptToken(key []byte, plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+
+	block, err := aes.NewCipher(key)
+
+	if err != nil {
+		return "", errors.Wrapf(err, "create AES cipher failed")
+	}
+
+	gcm, err := cipher.NewGCM(block)
+
+	if err != nil {
+		return "", errors.Wrapf(err, "create GCM mode failed")
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", errors.Wrapf(err, "generate nonce failed")
+	}
+
+	ciphertext := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// DecryptToken reverses EncryptToken.
+func DecryptToken(key []byte, encoded string) (string, error) {
+	if encoded == "" {
+		return "", nil
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+
+	if err != nil {
+		return "", errors.Wrapf(err, "decode token failed")
+	}
+
+	block, err := aes.NewCipher(key)
+
+	if err != nil {
+		return "", errors.Wrapf(err, "create AES cipher failed")
+	}
+
+	gcm, err := cipher.NewGCM(block)
+
+	if err != nil {
+		return "", errors.Wrapf(err, "create GCM mode failed")
+	}
+
+	if len(raw) < gcm.NonceSize() {
+		return "", errors.New("auth: encrypted token too short")
+	}
+
+	nonce, ciphertext := raw[:gcm.NonceSize()], raw[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+
+	if err != nil {
+		return "", errors.Wrapf(err, "decrypt token failed")
+	}
+
+	return string(plaintext), nil
+}
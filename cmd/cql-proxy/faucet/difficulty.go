@@ -0,0 +1,86 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package faucet
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	minDifficulty = 16
+	maxDifficulty = 28
+
+	// rateWindow is the sliding window over which the apply rate is measured.
+	rateWindow = time.Minute
+	// rateThreshold is the number of challenge issuances per rateWindow above which
+	// DifficultyController starts ratcheting difficulty up.
+	rateThreshold = 30
+)
+
+// DifficultyController adjusts PoW difficulty one bit at a time: every time recent issuance
+// rate exceeds rateThreshold it adds a bit, and every time it's at or below threshold it
+// relaxes by a bit, floored at minDifficulty (the baseline puzzle a quiet faucet issues) and
+// capped at maxDifficulty so a sustained flood can't demand an unsolvable proof.
+type DifficultyController struct {
+	mu         sync.Mutex
+	difficulty int
+	issuedAt   []time.Time
+}
+
+// NewDifficultyController returns a controller starting at minDifficulty.
+func NewDifficultyController() *DifficultyController {
+	return &DifficultyController{difficulty: minDifficulty}
+}
+
+// Current returns the difficulty to use for the next issued Challenge, adjusting it based on
+// the issuance rate observed over the trailing rateWindow.
+func (d *DifficultyController) Current() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	d.issuedAt = append(d.issuedAt, now)
+	d.issuedAt = trimOlderThan(d.issuedAt, now.Add(-rateWindow))
+
+	if len(d.issuedAt) > rateThreshold {
+		d.difficulty = clamp(d.difficulty+1, minDifficulty, maxDifficulty)
+	} else {
+		d.difficulty = clamp(d.difficulty-1, minDifficulty, maxDifficulty)
+	}
+
+	return d.difficulty
+}
+
+func trimOlderThan(ts []time.Time, cutoff time.Time) []time.Time {
+	for i, t := range ts {
+		if t.After(cutoff) {
+			return ts[i:]
+		}
+	}
+	return ts[:0]
+}
+
+func clamp(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
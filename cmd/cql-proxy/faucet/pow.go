@@ -0,0 +1,72 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package faucet gates cmd/cql-proxy's token-apply endpoint behind a Hashcash-style
+// proof-of-work challenge, and optionally a pluggable CAPTCHA check, so that spinning up many
+// developer accounts to dodge model.CheckTokenApplyLimits stops being free.
+package faucet
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+const nonceSize = 16
+
+// Challenge is a PoW puzzle issued to a developer: find a proof such that
+// SHA256(nonce || developerID || proof) has at least Difficulty leading zero bits.
+type Challenge struct {
+	Nonce      [nonceSize]byte
+	Difficulty int
+}
+
+// NewChallenge returns a fresh, randomly-nonced Challenge at the given difficulty.
+func NewChallenge(difficulty int) (*Challenge, error) {
+	c := &Challenge{Difficulty: difficulty}
+	if _, err := rand.Read(c.Nonce[:]); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Verify reports whether proof solves c for developer.
+func (c *Challenge) Verify(developer int64, proof []byte) bool {
+	h := sha256.New()
+	h.Write(c.Nonce[:])
+
+	var developerBuf [8]byte
+	binary.BigEndian.PutUint64(developerBuf[:], uint64(developer))
+	h.Write(developerBuf[:])
+
+	h.Write(proof)
+	return leadingZeroBits(h.Sum(nil)) >= c.Difficulty
+}
+
+func leadingZeroBits(sum []byte) int {
+	bits := 0
+	for _, b := range sum {
+		if b == 0 {
+			bits += 8
+			continue
+		}
+		for mask := byte(0x80); mask != 0 && b&mask == 0; mask >>= 1 {
+			bits++
+		}
+		break
+	}
+	return bits
+}
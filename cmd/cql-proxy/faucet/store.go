@@ -0,0 +1,122 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package faucet
+
+import (
+	"container/list"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+const (
+	challengeTTL      = 5 * time.Minute
+	challengeCapacity = 4096
+)
+
+type entry struct {
+	token     string
+	developer int64
+	challenge *Challenge
+	issuedAt  time.Time
+}
+
+// Store tracks issued Challenges in memory so a proof can only be redeemed once, within a
+// short TTL, before being evicted. It's bounded by challengeCapacity to keep a flood of
+// GET /api/faucet/challenge requests from growing the store without limit.
+type Store struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	order   *list.List
+	byToken map[string]*list.Element
+}
+
+// NewStore returns an empty Store.
+func NewStore() *Store {
+	return &Store{
+		ttl:     challengeTTL,
+		order:   list.New(),
+		byToken: make(map[string]*list.Element),
+	}
+}
+
+// Issue mints a new Challenge for developer and returns it along with an opaque token the
+// caller must present, together with a solving proof, to Redeem.
+func (s *Store) Issue(developer int64, difficulty int) (*Challenge, string, error) {
+	c, err := NewChallenge(difficulty)
+	if err != nil {
+		return nil, "", err
+	}
+
+	token := hex.EncodeToString(c.Nonce[:])
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.evictExpiredLocked(time.Now())
+	for s.order.Len() >= challengeCapacity {
+		s.evictOldestLocked()
+	}
+
+	e := &entry{token: token, developer: developer, challenge: c, issuedAt: time.Now()}
+	s.byToken[token] = s.order.PushBack(e)
+
+	return c, token, nil
+}
+
+// Redeem looks up the Challenge issued for token and removes it whenever found, so it can't be
+// replayed. It returns ok=false for a missing or expired token, or one issued to a different
+// developer.
+func (s *Store) Redeem(developer int64, token string) (c *Challenge, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, found := s.byToken[token]
+	if !found {
+		return nil, false
+	}
+
+	e := el.Value.(*entry)
+	s.order.Remove(el)
+	delete(s.byToken, token)
+
+	if e.developer != developer || time.Since(e.issuedAt) > s.ttl {
+		return nil, false
+	}
+
+	return e.challenge, true
+}
+
+func (s *Store) evictOldestLocked() {
+	el := s.order.Front()
+	if el == nil {
+		return
+	}
+	s.order.Remove(el)
+	delete(s.byToken, el.Value.(*entry).token)
+}
+
+func (s *Store) evictExpiredLocked(now time.Time) {
+	for {
+		el := s.order.Front()
+		if el == nil || now.Sub(el.Value.(*entry).issuedAt) <= s.ttl {
+			return
+		}
+		s.order.Remove(el)
+		delete(s.byToken, el.Value.(*entry).token)
+	}
+}
@@ -0,0 +1,167 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package metrics instruments cql-proxy with Prometheus metrics and exposes them on a separate
+// admin listener, kept off the public API port so /metrics can't be scraped by arbitrary
+// clients.
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	// HTTPRequestDuration tracks per-route HTTP latency, with exemplars linking slow buckets
+	// back to the trace that produced them.
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "cql_proxy",
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "HTTP request latency by route, method, and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	// HTTPRequestErrors counts 4xx and 5xx responses per route.
+	HTTPRequestErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cql_proxy",
+		Subsystem: "http",
+		Name:      "request_errors_total",
+		Help:      "4xx and 5xx HTTP responses by route and status.",
+	}, []string{"route", "status"})
+
+	// TaskQueueDepth reports model.TaskManager's pending task count per kind.
+	TaskQueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "cql_proxy",
+		Subsystem: "task",
+		Name:      "queue_depth",
+		Help:      "Pending async tasks by kind.",
+	}, []string{"kind"})
+
+	// TaskDuration tracks how long each async task kind takes to run, from dequeue to either
+	// success or failure.
+	TaskDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "cql_proxy",
+		Subsystem: "task",
+		Name:      "duration_seconds",
+		Help:      "Async task run time by kind and outcome.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"kind", "status"})
+
+	// BPRequestDuration tracks rpc.RequestBP call latency by BP RPC method.
+	BPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "cql_proxy",
+		Subsystem: "bp",
+		Name:      "request_duration_seconds",
+		Help:      "rpc.RequestBP call latency by method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "status"})
+
+	// FaucetQuotaRejections counts CheckTokenApplyLimits rejections by reason, so operators can
+	// tell a real outage from everyone simply hitting their daily quota.
+	FaucetQuotaRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cql_proxy",
+		Subsystem: "faucet",
+		Name:      "quota_rejections_total",
+		Help:      "Token apply requests rejected by CheckTokenApplyLimits, by reason.",
+	}, []string{"reason"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		HTTPRequestDuration,
+		HTTPRequestErrors,
+		TaskQueueDepth,
+		TaskDuration,
+		BPRequestDuration,
+		FaucetQuotaRejections,
+	)
+}
+
+// ObserveHTTPRequest records an HTTP request's outcome, attaching the request's trace ID as an
+// exemplar so a slow-request alert on the histogram can jump straight to its trace.
+func ObserveHTTPRequest(ctx context.Context, route string, method string, status int, d time.Duration) {
+	statusLabel := statusClass(status)
+	labels := prometheus.Labels{"route": route, "method": method, "status": statusLabel}
+
+	observeWithExemplar(ctx, HTTPRequestDuration.WithLabelValues(route, method, statusLabel), d, labels)
+
+	if status >= 400 {
+		HTTPRequestErrors.WithLabelValues(route, statusLabel).Inc()
+	}
+}
+
+// ObserveBPRequest records an rpc.RequestBP call's latency, keyed by the BP RPC method name
+// (e.g. route.MCCQueryAccountTokenBalance.String()).
+func ObserveBPRequest(ctx context.Context, method string, err error, d time.Duration) {
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+
+	labels := prometheus.Labels{"method": method, "status": status}
+	observeWithExemplar(ctx, BPRequestDuration.WithLabelValues(method, status), d, labels)
+}
+
+// ObserveTask records an async task's run time by kind and outcome.
+func ObserveTask(ctx context.Context, kind string, err error, d time.Duration) {
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+
+	labels := prometheus.Labels{"kind": kind, "status": status}
+	observeWithExemplar(ctx, TaskDuration.WithLabelValues(kind, status), d, labels)
+}
+
+// observeWithExemplar attaches the span active in ctx, if any, to the observation as an
+// exemplar, falling back to a plain Observe when there's no recording span.
+func observeWithExemplar(ctx context.Context, histogram prometheus.Observer, d time.Duration, labels prometheus.Labels) {
+	span := trace.SpanContextFromContext(ctx)
+
+	if eo, ok := histogram.(prometheus.ExemplarObserver); ok && span.IsSampled() {
+		eo.ObserveWithExemplar(d.Seconds(), prometheus.Labels{"trace_id": span.TraceID().String()})
+		return
+	}
+
+	histogram.Observe(d.Seconds())
+	_ = labels
+}
+
+func statusClass(status int) string {
+	switch {
+	case status >= 500:
+		return "5xx"
+	case status >= 400:
+		return "4xx"
+	case status >= 300:
+		return "3xx"
+	default:
+		return "2xx"
+	}
+}
+
+// StartAdminServer serves /metrics on addr, separate from the public API listener.
+func StartAdminServer(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	return http.ListenAndServe(addr, mux)
+}
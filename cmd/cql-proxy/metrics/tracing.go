@@ -0,0 +1,107 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/config"
+)
+
+const tracerName = "github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy"
+
+// InitTracer points cql-proxy's global TracerProvider at the OTLP collector described by
+// cfg, and returns a shutdown func to flush and close the exporter on server exit. A nil or
+// disabled cfg yields a no-op tracer so instrumentation stays cheap when telemetry isn't
+// configured.
+func InitTracer(ctx context.Context, cfg *config.TelemetryConfig) (shutdown func(context.Context) error, err error) {
+	if cfg == nil || !cfg.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	client := otlptracegrpc.NewClient(otlptracegrpc.WithEndpoint(cfg.OTLPEndpoint), otlptracegrpc.WithInsecure())
+	exporter, err := otlptrace.New(ctx, client)
+	if err != nil {
+		return nil, errors.Wrapf(err, "create OTLP exporter failed")
+	}
+
+	res, err := resource.New(ctx, resource.WithAttributes(
+		semconv.ServiceNameKey.String("cql-proxy"),
+	))
+	if err != nil {
+		return nil, errors.Wrapf(err, "build OTLP resource failed")
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.TraceIDRatioBased(cfg.SampleRatio)),
+	)
+	otel.SetTracerProvider(tp)
+
+	return tp.Shutdown, nil
+}
+
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// GinMiddleware starts a root span per request, named by route, and records its outcome as
+// HTTPRequestDuration/HTTPRequestErrors once the handler chain completes.
+func GinMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		ctx, span := tracer().Start(c.Request.Context(), c.Request.Method+" "+route)
+		span.SetAttributes(
+			attribute.String("http.method", c.Request.Method),
+			attribute.String("http.route", route),
+		)
+		c.Request = c.Request.WithContext(ctx)
+
+		start := time.Now()
+		c.Next()
+		d := time.Since(start)
+
+		status := c.Writer.Status()
+		span.SetAttributes(attribute.Int("http.status_code", status))
+		span.End()
+
+		ObserveHTTPRequest(ctx, route, c.Request.Method, status, d)
+	}
+}
+
+// StartSpan starts a child span under ctx, for instrumenting a single step of a longer-running
+// task (e.g. ApplyTokenTask's client.TransferToken and waitForTxState calls).
+func StartSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return tracer().Start(ctx, name)
+}
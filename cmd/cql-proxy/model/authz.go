@@ -0,0 +1,152 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package model
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+// ProjectACL grants developer a Role on a single Project, overriding their Org role - e.g. to
+// share a project with someone outside the owning org.
+type ProjectACL struct {
+	ID        int64 `db:"id"`
+	Project   int64 `db:"project_id"`
+	Developer int64 `db:"developer_id"`
+	Role      Role  `db:"role"`
+}
+
+func AddProjectACL(db DB, project int64, developer int64, role Role) (a *ProjectACL, err error) {
+	a = &ProjectACL{Project: project, Developer: developer, Role: role}
+	err = db.Insert(a)
+	if err != nil {
+		err = errors.Wrapf(err, "add project acl failed")
+	}
+	return
+}
+
+func GetProjectACL(db DB, project int64, developer int64) (a *ProjectACL, err error) {
+	err = db.SelectOne(&a, `SELECT * FROM "project_acl" WHERE "project_id" = ? AND "developer_id" = ? LIMIT 1`, project, developer)
+	if err != nil {
+		err = errors.Wrapf(err, "get project acl failed")
+	}
+	return
+}
+
+func GetProjectACLs(db DB, project int64) (a []*ProjectACL, err error) {
+	_, err = db.Select(&a, `SELECT * FROM "project_acl" WHERE "project_id" = ?`, project)
+	if err != nil {
+		err = errors.Wrapf(err, "get project acls failed")
+	}
+	return
+}
+
+func SetProjectACLRole(db DB, project int64, developer int64, role Role) (err error) {
+	_, err = db.Exec(`UPDATE "project_acl" SET "role" = ? WHERE "project_id" = ? AND "developer_id" = ?`, role, project, developer)
+	if err != nil {
+		err = errors.Wrapf(err, "set project acl role failed")
+	}
+	return
+}
+
+func RemoveProjectACL(db DB, project int64, developer int64) (err error) {
+	_, err = db.Exec(`DELETE FROM "project_acl" WHERE "project_id" = ? AND "developer_id" = ?`, project, developer)
+	if err != nil {
+		err = errors.Wrapf(err, "remove project acl failed")
+	}
+	return
+}
+
+// Action is an operation a developer may want to perform on a Project.
+type Action string
+
+const (
+	ActionView      Action = "view"
+	ActionEdit      Action = "edit"
+	ActionDelete    Action = "delete"
+	ActionManageACL Action = "manage_acl"
+)
+
+// actionMinRole is the least privileged Role that may perform Action.
+var actionMinRole = map[Action]Role{
+	ActionView:      RoleViewer,
+	ActionEdit:      RoleDeveloper,
+	ActionDelete:    RoleAdmin,
+	ActionManageACL: RoleOwner,
+}
+
+// ErrForbidden is returned by CanActOnProject when developer's effective Role doesn't meet the
+// action's minimum.
+var ErrForbidden = errors.New("forbidden")
+
+// CanActOnProject resolves developer's effective Role on the project identified by dbID - a
+// direct ProjectACL grant if one exists, else their membership in the project's owning Org -
+// and returns ErrForbidden if that role isn't sufficient for action.
+func CanActOnProject(db DB, developer int64, dbID proto.DatabaseID, action Action) (err error) {
+	p, err := getProjectRaw(db, dbID)
+	if err != nil {
+		return
+	}
+
+	role, err := effectiveRole(db, developer, p)
+	if err != nil {
+		return
+	}
+
+	min, ok := actionMinRole[action]
+	if !ok || !role.atLeast(min) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// CanActOnOrg returns ErrForbidden unless developer's membership role in org meets min.
+func CanActOnOrg(db DB, developer int64, org int64, min Role) (err error) {
+	member, err := GetOrgMember(db, org, developer)
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return ErrForbidden
+		}
+		return
+	}
+
+	if !member.Role.atLeast(min) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+func effectiveRole(db DB, developer int64, p *Project) (Role, error) {
+	acl, err := GetProjectACL(db, p.ID, developer)
+	if err == nil {
+		return acl.Role, nil
+	} else if errors.Cause(err) != sql.ErrNoRows {
+		return "", err
+	}
+
+	member, err := GetOrgMember(db, p.Org, developer)
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return "", ErrForbidden
+		}
+		return "", err
+	}
+	return member.Role, nil
+}
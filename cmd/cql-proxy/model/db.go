@@ -0,0 +1,324 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package model
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+const (
+	maxOpenConns    = 32
+	maxIdleConns    = 8
+	connMaxLifetime = time.Hour
+)
+
+// DB is the minimal surface this package needs from its SQL layer - every AddX/GetX/SetX
+// function in this package takes a DB rather than a concrete driver type, so unit tests can
+// substitute a mock.
+//
+// Select and SelectOne work directly off each struct's `db` tags, matching result columns to
+// fields by name - the same binding convention AddX/GetX already wrote their queries against,
+// so replacing the engine under them didn't require touching a single query string.
+type DB interface {
+	Select(i interface{}, query string, args ...interface{}) ([]interface{}, error)
+	SelectOne(holder interface{}, query string, args ...interface{}) error
+	Insert(list ...interface{}) error
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// dialectDB rewrites every query's ANSI-quoted identifiers and "?" bindvars for drivers that
+// don't understand them (see Dialect) before delegating to the underlying DB.
+type dialectDB struct {
+	DB
+	dialect Dialect
+}
+
+func (d *dialectDB) Select(i interface{}, query string, args ...interface{}) ([]interface{}, error) {
+	return d.DB.Select(i, d.dialect.Rewrite(query), args...)
+}
+
+func (d *dialectDB) SelectOne(holder interface{}, query string, args ...interface{}) error {
+	return d.DB.SelectOne(holder, d.dialect.Rewrite(query), args...)
+}
+
+func (d *dialectDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return d.DB.Exec(d.dialect.Rewrite(query), args...)
+}
+
+// Open connects to driver ("sqlite3", "mysql", or "postgres") at dsn, applies pending
+// migrations from migrationsDir, and returns a DB with pool defaults set and driver-appropriate
+// identifier quoting and bindvars applied to every query.
+func Open(driver string, dsn string) (DB, error) {
+	sqlDB, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open %s connection failed", driver)
+	}
+
+	sqlDB.SetMaxOpenConns(maxOpenConns)
+	sqlDB.SetMaxIdleConns(maxIdleConns)
+	sqlDB.SetConnMaxLifetime(connMaxLifetime)
+
+	if err = sqlDB.Ping(); err != nil {
+		return nil, errors.Wrapf(err, "ping %s connection failed", driver)
+	}
+
+	if err = Migrate(sqlDB, driver); err != nil {
+		return nil, errors.Wrapf(err, "run migrations failed")
+	}
+
+	dialect := DialectFor(driver)
+	db := &dialectDB{DB: &sqlEngine{db: sqlDB, dialect: dialect}, dialect: dialect}
+
+	// Projects that predate migration 000003 come back from it with org_id = 0, which matches
+	// no real org - without this, RBAC would lock every developer out of their own pre-existing
+	// projects. BackfillPersonalOrgs is a no-op once every project has been assigned one.
+	if err = BackfillPersonalOrgs(db); err != nil {
+		return nil, errors.Wrapf(err, "backfill personal orgs failed")
+	}
+
+	return db, nil
+}
+
+// sqlEngine is a minimal prepared-statement ORM over database/sql, binding rows to structs
+// purely through their `db` tags. It replaces the gorp.DbMap this package used to sit on -
+// dialectDB still owns identifier/bindvar rewriting, sqlEngine just needs a *sql.DB to run
+// against.
+type sqlEngine struct {
+	db      *sql.DB
+	dialect Dialect
+}
+
+// Select runs query and scans every row into a new element of the slice i points to (e.g.
+// i is &[]*Project{}).
+func (e *sqlEngine) Select(i interface{}, query string, args ...interface{}) ([]interface{}, error) {
+	sliceVal := reflect.ValueOf(i)
+	if sliceVal.Kind() != reflect.Ptr || sliceVal.Elem().Kind() != reflect.Slice {
+		return nil, errors.Errorf("select destination must be a pointer to a slice, got %T", i)
+	}
+
+	slice := sliceVal.Elem()
+	elemType := slice.Type().Elem()
+	structType := elemType
+	if structType.Kind() == reflect.Ptr {
+		structType = structType.Elem()
+	}
+
+	rows, err := e.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []interface{}
+	for rows.Next() {
+		rowPtr, scanErr := scanStruct(rows, structType)
+		if scanErr != nil {
+			return nil, scanErr
+		}
+
+		if elemType.Kind() == reflect.Ptr {
+			slice.Set(reflect.Append(slice, rowPtr))
+		} else {
+			slice.Set(reflect.Append(slice, rowPtr.Elem()))
+		}
+		out = append(out, rowPtr.Interface())
+	}
+
+	return out, rows.Err()
+}
+
+// SelectOne runs query, which must return at most one row, and stores it through holder (e.g.
+// holder is &p where p is a *Project). A query with no rows returns sql.ErrNoRows, matching
+// the errors.Cause(err) != sql.ErrNoRows idiom this package's callers already rely on.
+func (e *sqlEngine) SelectOne(holder interface{}, query string, args ...interface{}) error {
+	holderVal := reflect.ValueOf(holder)
+	if holderVal.Kind() != reflect.Ptr || holderVal.Elem().Kind() != reflect.Ptr {
+		return errors.Errorf("selectOne destination must be a pointer to a pointer, got %T", holder)
+	}
+
+	structType := holderVal.Elem().Type().Elem()
+
+	rows, err := e.db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		return sql.ErrNoRows
+	}
+
+	rowPtr, err := scanStruct(rows, structType)
+	if err != nil {
+		return err
+	}
+
+	holderVal.Elem().Set(rowPtr)
+	return nil
+}
+
+// Insert inserts every argument, each a pointer to a struct registered via tableName, and
+// writes back the driver-assigned id into its `db:"id"` field.
+func (e *sqlEngine) Insert(list ...interface{}) error {
+	for _, v := range list {
+		if err := e.insertOne(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *sqlEngine) insertOne(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return errors.Errorf("insert target must be a pointer to a struct, got %T", v)
+	}
+
+	elem := rv.Elem()
+	t := elem.Type()
+	table := tableName(t)
+
+	var (
+		cols    []string
+		phs     []string
+		args    []interface{}
+		idField = -1
+	)
+
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag.Get("db")
+		if tag == "" {
+			continue
+		}
+		if tag == "id" {
+			idField = i
+			continue
+		}
+		cols = append(cols, fmt.Sprintf("%q", tag))
+		phs = append(phs, "?")
+		args = append(args, elem.Field(i).Interface())
+	}
+
+	query := fmt.Sprintf(`INSERT INTO %q (%s) VALUES (%s)`, table, strings.Join(cols, ", "), strings.Join(phs, ", "))
+
+	if e.dialect.UsesLastInsertID() {
+		res, err := e.db.Exec(e.dialect.Rewrite(query), args...)
+		if err != nil {
+			return errors.Wrapf(err, "insert into %s failed", table)
+		}
+
+		if idField >= 0 {
+			id, idErr := res.LastInsertId()
+			if idErr != nil {
+				return errors.Wrapf(idErr, "read last insert id for %s failed", table)
+			}
+			elem.Field(idField).SetInt(id)
+		}
+		return nil
+	}
+
+	query = e.dialect.AppendReturningID(query, "id")
+	row := e.db.QueryRow(e.dialect.Rewrite(query), args...)
+
+	if idField < 0 {
+		var discard int64
+		return row.Scan(&discard)
+	}
+
+	var id int64
+	if err := row.Scan(&id); err != nil {
+		return errors.Wrapf(err, "insert into %s failed", table)
+	}
+	elem.Field(idField).SetInt(id)
+	return nil
+}
+
+// Exec runs a statement that doesn't return rows.
+func (e *sqlEngine) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return e.db.Exec(query, args...)
+}
+
+// scanStruct scans rows' current row into a new *T (T being structType), matching result
+// columns to fields by their `db` tag.
+func scanStruct(rows *sql.Rows, structType reflect.Type) (reflect.Value, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return reflect.Value{}, err
+	}
+
+	fieldByCol := make(map[string]int, structType.NumField())
+	for i := 0; i < structType.NumField(); i++ {
+		if tag := structType.Field(i).Tag.Get("db"); tag != "" {
+			fieldByCol[tag] = i
+		}
+	}
+
+	ptr := reflect.New(structType)
+	elem := ptr.Elem()
+
+	dest := make([]interface{}, len(columns))
+	for i, col := range columns {
+		if fi, ok := fieldByCol[col]; ok {
+			dest[i] = elem.Field(fi).Addr().Interface()
+		} else {
+			var discard interface{}
+			dest[i] = &discard
+		}
+	}
+
+	if err = rows.Scan(dest...); err != nil {
+		return reflect.Value{}, err
+	}
+
+	return ptr, nil
+}
+
+// tableName derives a struct's table name from its type name (e.g. WebhookDeadLetter ->
+// webhook_dead_letter), matching the naming this package's migrations already use - so adding
+// a new model only ever means adding its struct, never a separate registration step.
+func tableName(t reflect.Type) string {
+	var b strings.Builder
+	runes := []rune(t.Name())
+
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			prevLower := i > 0 && unicode.IsLower(runes[i-1])
+			nextLower := i > 0 && i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1])
+			if prevLower || nextLower {
+				b.WriteRune('_')
+			}
+			b.WriteRune(unicode.ToLower(r))
+			continue
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
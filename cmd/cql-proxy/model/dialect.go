@@ -0,0 +1,98 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Dialect adapts the ANSI double-quoted identifiers and "?" bindvars every query in this
+// package is written with - valid as-is against SQLite - to whatever
+// config.Config.Storage.Driver actually understands, and bridges the one true behavioral
+// difference Insert cares about: how a driver hands back an auto-generated id.
+type Dialect interface {
+	// Rewrite adapts query's identifier quoting and bindvar placeholders to this dialect.
+	Rewrite(query string) string
+
+	// UsesLastInsertID reports whether sql.Result.LastInsertId() works for this driver. It
+	// doesn't for postgres - callers should use AppendReturningID instead.
+	UsesLastInsertID() bool
+
+	// AppendReturningID appends whatever's needed for an INSERT to hand back idColumn (a
+	// "RETURNING" clause on postgres; a no-op everywhere else).
+	AppendReturningID(query string, idColumn string) string
+}
+
+var quotedIdent = regexp.MustCompile(`"([A-Za-z_][A-Za-z0-9_]*)"`)
+
+// ansiDialect passes queries through unchanged, for drivers (sqlite3) that already accept
+// double-quoted identifiers, "?" bindvars, and LastInsertId.
+type ansiDialect struct{}
+
+func (ansiDialect) Rewrite(query string) string { return query }
+
+func (ansiDialect) UsesLastInsertID() bool { return true }
+
+func (ansiDialect) AppendReturningID(query string, idColumn string) string { return query }
+
+// mysqlDialect rewrites double-quoted identifiers to MySQL's backtick quoting; "?" bindvars
+// and LastInsertId work as-is.
+type mysqlDialect struct{}
+
+func (mysqlDialect) Rewrite(query string) string {
+	return quotedIdent.ReplaceAllString(query, "`$1`")
+}
+
+func (mysqlDialect) UsesLastInsertID() bool { return true }
+
+func (mysqlDialect) AppendReturningID(query string, idColumn string) string { return query }
+
+var positionalPlaceholder = regexp.MustCompile(`\?`)
+
+// postgresDialect accepts ANSI double-quoted identifiers as-is, but needs "?" bindvars
+// renumbered to "$1", "$2", ... and has no LastInsertId support, so Insert instead asks for
+// the id back via RETURNING.
+type postgresDialect struct{}
+
+func (postgresDialect) Rewrite(query string) string {
+	n := 0
+	return positionalPlaceholder.ReplaceAllStringFunc(query, func(string) string {
+		n++
+		return fmt.Sprintf("$%d", n)
+	})
+}
+
+func (postgresDialect) UsesLastInsertID() bool { return false }
+
+func (postgresDialect) AppendReturningID(query string, idColumn string) string {
+	return strings.TrimSuffix(query, " ") + fmt.Sprintf(` RETURNING %q`, idColumn)
+}
+
+// DialectFor returns the Dialect for a config.Config.Storage.Driver value ("sqlite3",
+// "postgres", or "mysql").
+func DialectFor(driver string) Dialect {
+	switch driver {
+	case "mysql":
+		return mysqlDialect{}
+	case "postgres":
+		return postgresDialect{}
+	default:
+		return ansiDialect{}
+	}
+}
@@ -0,0 +1,95 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package model
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+// ExternalIdentity links a Developer to one external login, e.g. a GitHub account or an OIDC
+// subject at a given issuer. A Developer may have more than one, so they can sign in with
+// whichever provider is convenient.
+type ExternalIdentity struct {
+	ID           int64  `db:"id"`
+	Developer    int64  `db:"developer_id"`
+	Provider     string `db:"provider"`
+	Subject      string `db:"subject"`
+	Email        string `db:"email"`
+	RefreshToken string `db:"refresh_token"` // stored encrypted; see EncryptToken/DecryptToken
+}
+
+// GetDeveloperByExternalIdentity resolves an already-linked external identity back to its
+// Developer row, or sql.ErrNoRows if provider/subject has never logged in before.
+func GetDeveloperByExternalIdentity(db DB, provider, subject string) (d *Developer, err error) {
+	err = db.SelectOne(&d,
+		`SELECT "developer".* FROM "developer"
+		 JOIN "external_identity" ON "external_identity"."developer_id" = "developer"."id"
+		 WHERE "external_identity"."provider" = ? AND "external_identity"."subject" = ?
+		 LIMIT 1`,
+		provider, subject)
+	if err != nil {
+		err = errors.Wrapf(err, "get developer by external identity failed")
+	}
+	return
+}
+
+// LinkExternalIdentity resolves provider/subject to a Developer, auto-provisioning both a new
+// Developer and the ExternalIdentity row on first login, and otherwise just refreshing the
+// stored email/refresh token on subsequent logins.
+func LinkExternalIdentity(db DB, provider, subject, email, encryptedRefreshToken string) (d *Developer, err error) {
+	d, err = GetDeveloperByExternalIdentity(db, provider, subject)
+
+	switch err {
+	case nil:
+		_, err = db.Exec(
+			`UPDATE "external_identity" SET "email" = ?, "refresh_token" = ?
+			 WHERE "provider" = ? AND "subject" = ?`,
+			email, encryptedRefreshToken, provider, subject)
+		if err != nil {
+			err = errors.Wrapf(err, "update external identity failed")
+		}
+		return
+	default:
+		if errors.Cause(err) != sql.ErrNoRows {
+			return nil, err
+		}
+	}
+
+	// First login from this provider/subject: provision a Developer and link it.
+	d = &Developer{Email: email}
+
+	if err = db.Insert(d); err != nil {
+		err = errors.Wrapf(err, "provision developer failed")
+		return
+	}
+
+	identity := &ExternalIdentity{
+		Developer:    d.ID,
+		Provider:     provider,
+		Subject:      subject,
+		Email:        email,
+		RefreshToken: encryptedRefreshToken,
+	}
+
+	if err = db.Insert(identity); err != nil {
+		err = errors.Wrapf(err, "link external identity failed")
+	}
+
+	return
+}
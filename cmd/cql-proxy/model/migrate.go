@@ -0,0 +1,164 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package model
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// migrationsDir holds the numbered up/down SQL files Migrate applies, relative to the
+// cql-proxy binary's working directory.
+var migrationsDir = "cmd/cql-proxy/model/migrations"
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+type migration struct {
+	version int
+	name    string
+	up      string
+}
+
+// Migrate brings db up to the latest migration in migrationsDir, recording each applied
+// version in a schema_migrations table so re-running Migrate against an up-to-date database is
+// a no-op.
+func Migrate(db *sql.DB, driver string) (err error) {
+	if err = ensureMigrationsTable(db); err != nil {
+		return
+	}
+
+	current, err := currentVersion(db)
+	if err != nil {
+		return
+	}
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		return
+	}
+
+	dialect := DialectFor(driver)
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+
+		if err = applyMigration(db, dialect, m); err != nil {
+			return
+		}
+	}
+
+	return nil
+}
+
+func applyMigration(db *sql.DB, dialect Dialect, m migration) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return errors.Wrapf(err, "begin migration %d failed", m.version)
+	}
+
+	for _, stmt := range splitStatements(m.up) {
+		if _, err = tx.Exec(dialect.Rewrite(stmt)); err != nil {
+			_ = tx.Rollback()
+			return errors.Wrapf(err, "apply migration %d (%s) failed", m.version, m.name)
+		}
+	}
+
+	if _, err = tx.Exec(dialect.Rewrite(`INSERT INTO schema_migrations (version) VALUES (?)`), m.version); err != nil {
+		_ = tx.Rollback()
+		return errors.Wrapf(err, "record migration %d failed", m.version)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.Wrapf(err, "commit migration %d failed", m.version)
+	}
+	return nil
+}
+
+// splitStatements breaks a migration file's body into its individual ";"-terminated
+// statements. go-sql-driver/mysql, unlike the sqlite3 and lib/pq drivers, refuses a query
+// containing more than one statement unless the DSN opts into multiStatements - executing each
+// statement as its own tx.Exec works the same way against every supported driver, so no such
+// DSN option needs to be documented or required. Migration files are plain DDL with no
+// semicolons inside string literals, so splitting on ";" is exact.
+func splitStatements(body string) (stmts []string) {
+	for _, raw := range strings.Split(body, ";") {
+		if stmt := strings.TrimSpace(raw); stmt != "" {
+			stmts = append(stmts, stmt)
+		}
+	}
+	return
+}
+
+func ensureMigrationsTable(db *sql.DB) (err error) {
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`)
+	if err != nil {
+		err = errors.Wrapf(err, "create schema_migrations table failed")
+	}
+	return
+}
+
+func currentVersion(db *sql.DB) (version int, err error) {
+	row := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`)
+	if err = row.Scan(&version); err != nil {
+		err = errors.Wrapf(err, "read schema_migrations version failed")
+	}
+	return
+}
+
+func loadMigrations() (migrations []migration, err error) {
+	files, err := ioutil.ReadDir(migrationsDir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read migrations directory failed")
+	}
+
+	byVersion := make(map[int]*migration)
+
+	for _, f := range files {
+		m := migrationFileName.FindStringSubmatch(f.Name())
+		if m == nil || m[3] != "up" {
+			continue
+		}
+
+		version, convErr := strconv.Atoi(m[1])
+		if convErr != nil {
+			continue
+		}
+
+		body, readErr := ioutil.ReadFile(filepath.Join(migrationsDir, f.Name()))
+		if readErr != nil {
+			return nil, errors.Wrapf(readErr, "read migration %s failed", f.Name())
+		}
+
+		byVersion[version] = &migration{version: version, name: m[2], up: string(body)}
+	}
+
+	for _, m := range byVersion {
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	return
+}
@@ -0,0 +1,181 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package model
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Role is a developer's level of access within an Org, or granted directly on a single Project
+// via ProjectACL. Roles rank owner > admin > developer > viewer.
+type Role string
+
+const (
+	RoleOwner     Role = "owner"
+	RoleAdmin     Role = "admin"
+	RoleDeveloper Role = "developer"
+	RoleViewer    Role = "viewer"
+)
+
+var roleRank = map[Role]int{
+	RoleViewer:    1,
+	RoleDeveloper: 2,
+	RoleAdmin:     3,
+	RoleOwner:     4,
+}
+
+func (r Role) atLeast(min Role) bool {
+	return roleRank[r] >= roleRank[min]
+}
+
+// Org is a team that owns zero or more Projects. Every developer has exactly one Personal org,
+// auto-created the first time they create a project, so solo use keeps working unchanged.
+type Org struct {
+	ID        int64  `db:"id"`
+	Name      string `db:"name"`
+	Personal  bool   `db:"personal"`
+	CreatedAt int64  `db:"created_at"`
+}
+
+// OrgMember grants developer a Role within org.
+type OrgMember struct {
+	ID        int64 `db:"id"`
+	Org       int64 `db:"org_id"`
+	Developer int64 `db:"developer_id"`
+	Role      Role  `db:"role"`
+}
+
+func AddOrg(db DB, name string, personal bool, now int64) (o *Org, err error) {
+	o = &Org{Name: name, Personal: personal, CreatedAt: now}
+	err = db.Insert(o)
+	if err != nil {
+		err = errors.Wrapf(err, "add org failed")
+	}
+	return
+}
+
+func GetOrg(db DB, id int64) (o *Org, err error) {
+	err = db.SelectOne(&o, `SELECT * FROM "org" WHERE "id" = ? LIMIT 1`, id)
+	if err != nil {
+		err = errors.Wrapf(err, "get org failed")
+	}
+	return
+}
+
+func GetOrgsForDeveloper(db DB, developer int64) (o []*Org, err error) {
+	_, err = db.Select(&o,
+		`SELECT "org".* FROM "org"
+		 JOIN "org_member" ON "org_member"."org_id" = "org"."id"
+		 WHERE "org_member"."developer_id" = ?`,
+		developer)
+	if err != nil {
+		err = errors.Wrapf(err, "get orgs for developer failed")
+	}
+	return
+}
+
+// EnsurePersonalOrg returns developer's personal Org, creating one and seeding developer as its
+// owner on first use.
+func EnsurePersonalOrg(db DB, developer int64) (o *Org, err error) {
+	orgs, err := GetOrgsForDeveloper(db, developer)
+	if err != nil {
+		return
+	}
+
+	for _, candidate := range orgs {
+		if candidate.Personal {
+			return candidate, nil
+		}
+	}
+
+	o, err = AddOrg(db, "", true, time.Now().Unix())
+	if err != nil {
+		return
+	}
+
+	if _, err = AddOrgMember(db, o.ID, developer, RoleOwner); err != nil {
+		err = errors.Wrapf(err, "seed personal org owner failed")
+	}
+	return
+}
+
+// BackfillPersonalOrgs assigns every Project still missing an org_id (left over from the old
+// single-developer ownership model) to its developer's personal Org, so existing projects keep
+// working under RBAC without anyone having to act. Safe to run more than once.
+func BackfillPersonalOrgs(db DB) (err error) {
+	var projects []*Project
+	_, err = db.Select(&projects, `SELECT * FROM "project" WHERE "org_id" = 0 OR "org_id" IS NULL`)
+	if err != nil {
+		return errors.Wrapf(err, "list unmigrated projects failed")
+	}
+
+	for _, p := range projects {
+		var org *Org
+		org, err = EnsurePersonalOrg(db, p.Developer)
+		if err != nil {
+			return errors.Wrapf(err, "ensure personal org for developer %d failed", p.Developer)
+		}
+
+		if _, err = db.Exec(`UPDATE "project" SET "org_id" = ? WHERE "id" = ?`, org.ID, p.ID); err != nil {
+			return errors.Wrapf(err, "backfill project %d org failed", p.ID)
+		}
+	}
+	return nil
+}
+
+func AddOrgMember(db DB, org int64, developer int64, role Role) (m *OrgMember, err error) {
+	m = &OrgMember{Org: org, Developer: developer, Role: role}
+	err = db.Insert(m)
+	if err != nil {
+		err = errors.Wrapf(err, "add org member failed")
+	}
+	return
+}
+
+func GetOrgMember(db DB, org int64, developer int64) (m *OrgMember, err error) {
+	err = db.SelectOne(&m, `SELECT * FROM "org_member" WHERE "org_id" = ? AND "developer_id" = ? LIMIT 1`, org, developer)
+	if err != nil {
+		err = errors.Wrapf(err, "get org member failed")
+	}
+	return
+}
+
+func GetOrgMembers(db DB, org int64) (m []*OrgMember, err error) {
+	_, err = db.Select(&m, `SELECT * FROM "org_member" WHERE "org_id" = ?`, org)
+	if err != nil {
+		err = errors.Wrapf(err, "get org members failed")
+	}
+	return
+}
+
+func SetOrgMemberRole(db DB, org int64, developer int64, role Role) (err error) {
+	_, err = db.Exec(`UPDATE "org_member" SET "role" = ? WHERE "org_id" = ? AND "developer_id" = ?`, role, org, developer)
+	if err != nil {
+		err = errors.Wrapf(err, "set org member role failed")
+	}
+	return
+}
+
+func RemoveOrgMember(db DB, org int64, developer int64) (err error) {
+	_, err = db.Exec(`DELETE FROM "org_member" WHERE "org_id" = ? AND "developer_id" = ?`, org, developer)
+	if err != nil {
+		err = errors.Wrapf(err, "remove org member failed")
+	}
+	return
+}
@@ -18,7 +18,6 @@ package model
 
 import (
 	"github.com/pkg/errors"
-	gorp "gopkg.in/gorp.v2"
 
 	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
 	"github.com/CovenantSQL/CovenantSQL/proto"
@@ -30,23 +29,44 @@ type Project struct {
 	Alias     string           `db:"alias"`
 	Developer int64            `db:"developer_id"`
 	Account   int64            `db:"account_id"`
+	Org       int64            `db:"org_id"`
 }
 
-func AddProject(db *gorp.DbMap, dbID proto.DatabaseID, developer int64, account int64) (p *Project, err error) {
+// OnProjectCreated, if set, is called after a project is successfully inserted, letting the
+// webhook dispatcher fan out a WebhookEventProjectCreated delivery without this package having
+// to import it directly.
+var OnProjectCreated func(developer int64, p *Project)
+
+// OnProjectDeleted, if set, is called after a project is successfully deleted.
+var OnProjectDeleted func(developer int64, dbID proto.DatabaseID)
+
+func AddProject(db DB, dbID proto.DatabaseID, developer int64, account int64) (p *Project, err error) {
+	org, err := EnsurePersonalOrg(db, developer)
+	if err != nil {
+		err = errors.Wrapf(err, "resolve personal org failed")
+		return
+	}
+
 	p = &Project{
 		DB:        dbID,
 		Alias:     string(dbID)[:8],
 		Developer: developer,
 		Account:   account,
+		Org:       org.ID,
 	}
 	err = db.Insert(p)
 	if err != nil {
 		err = errors.Wrapf(err, "add project failed")
+		return
+	}
+
+	if OnProjectCreated != nil {
+		OnProjectCreated(developer, p)
 	}
 	return
 }
 
-func GetProject(db *gorp.DbMap, name string) (p *Project, err error) {
+func GetProject(db DB, name string) (p *Project, err error) {
 	// if the alias fits to a hash, query using database id
 	var h hash.Hash
 	err = hash.Decode(&h, name)
@@ -65,46 +85,77 @@ func GetProject(db *gorp.DbMap, name string) (p *Project, err error) {
 	return
 }
 
-func GetProjectByID(db *gorp.DbMap, dbID proto.DatabaseID, developer int64) (p *Project, err error) {
-	err = db.SelectOne(&p,
-		`SELECT * FROM "project" WHERE "database_id" = ? AND "developer_id" = ? LIMIT 1`,
-		dbID, developer)
+// getProjectRaw fetches a project by database id with no authorization check - callers outside
+// this package must go through GetProjectByID or CanActOnProject instead.
+func getProjectRaw(db DB, dbID proto.DatabaseID) (p *Project, err error) {
+	err = db.SelectOne(&p, `SELECT * FROM "project" WHERE "database_id" = ? LIMIT 1`, dbID)
 	if err != nil {
 		err = errors.Wrapf(err, "get project failed")
 	}
 	return
 }
 
-func GetProjects(db *gorp.DbMap, developer int64, account int64) (p []*Project, err error) {
-	if account == 0 {
-		_, err = db.Select(&p, `SELECT * FROM "project" WHERE "developer_id" = ?`, developer)
-	} else {
-		_, err = db.Select(&p, `SELECT * FROM "project" WHERE "developer_id" = ? AND "account_id" = ?`,
-			developer, account)
+// GetProjectByID returns the project identified by dbID if developer has at least ActionView
+// on it, via either their Org membership or a ProjectACL grant.
+func GetProjectByID(db DB, dbID proto.DatabaseID, developer int64) (p *Project, err error) {
+	if err = CanActOnProject(db, developer, dbID, ActionView); err != nil {
+		return
+	}
+	return getProjectRaw(db, dbID)
+}
+
+// GetProjects lists every project developer has at least ActionView on, across their own orgs
+// and any project they've been granted direct ProjectACL access to.
+func GetProjects(db DB, developer int64, account int64) (p []*Project, err error) {
+	where := `("org_member"."id" IS NOT NULL OR "project_acl"."id" IS NOT NULL)`
+	args := []interface{}{developer, developer}
+
+	if account != 0 {
+		where += ` AND "project"."account_id" = ?`
+		args = append(args, account)
 	}
+
+	_, err = db.Select(&p, `
+		SELECT DISTINCT "project".* FROM "project"
+		LEFT JOIN "org_member" ON "org_member"."org_id" = "project"."org_id" AND "org_member"."developer_id" = ?
+		LEFT JOIN "project_acl" ON "project_acl"."project_id" = "project"."id" AND "project_acl"."developer_id" = ?
+		WHERE `+where, args...)
 	if err != nil {
 		err = errors.Wrapf(err, "get projects failed")
 	}
 	return
 }
 
-func DeleteProject(db *gorp.DbMap, dbID proto.DatabaseID, developer int64) (err error) {
-	_, err = db.Exec(
-		`DELETE FROM "project" WHERE "database_id" = ? AND "developer_id" = ?`,
-		dbID, developer)
+// DeleteProject deletes the project identified by dbID if developer has at least ActionDelete
+// on it.
+func DeleteProject(db DB, dbID proto.DatabaseID, developer int64) (err error) {
+	if err = CanActOnProject(db, developer, dbID, ActionDelete); err != nil {
+		return
+	}
+
+	_, err = db.Exec(`DELETE FROM "project" WHERE "database_id" = ?`, dbID)
 	if err != nil {
 		err = errors.Wrapf(err, "delete project failed")
+		return
+	}
+
+	if OnProjectDeleted != nil {
+		OnProjectDeleted(developer, dbID)
 	}
 	return
 }
 
-func SetProjectAlias(db *gorp.DbMap, dbID proto.DatabaseID, developer int64, alias string) (err error) {
+// SetProjectAlias renames the project identified by dbID if developer has at least ActionEdit
+// on it.
+func SetProjectAlias(db DB, dbID proto.DatabaseID, developer int64, alias string) (err error) {
+	if err = CanActOnProject(db, developer, dbID, ActionEdit); err != nil {
+		return
+	}
+
 	if alias == "" {
 		alias = string(dbID)
 	}
-	_, err = db.Exec(
-		`UPDATE "project" SET "alias" = ? WHERE "database_id" = ? AND "developer_id" = ?`,
-		alias, dbID, developer)
+	_, err = db.Exec(`UPDATE "project" SET "alias" = ? WHERE "database_id" = ?`, alias, dbID)
 	if err != nil {
 		err = errors.Wrapf(err, "set project alias failed")
 	}
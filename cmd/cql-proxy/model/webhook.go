@@ -0,0 +1,123 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package model
+
+import (
+	"github.com/pkg/errors"
+)
+
+// Webhook event bits, combined into a Webhook's EventMask to pick which events a subscription
+// should receive.
+const (
+	WebhookEventProjectCreated int64 = 1 << iota
+	WebhookEventProjectDeleted
+	WebhookEventTokenApplyStateChanged
+	WebhookEventMainAccountChanged
+	WebhookEventTaskCompleted
+)
+
+type Webhook struct {
+	ID        int64  `db:"id"`
+	Developer int64  `db:"developer_id"`
+	URL       string `db:"url"`
+	Secret    string `db:"secret"`
+	EventMask int64  `db:"event_mask"`
+	CreatedAt int64  `db:"created_at"`
+}
+
+type WebhookDeadLetter struct {
+	ID         int64  `db:"id"`
+	Webhook    int64  `db:"webhook_id"`
+	Payload    string `db:"payload"`
+	Error      string `db:"error"`
+	OccurredAt int64  `db:"occurred_at"`
+}
+
+func AddWebhook(db DB, developer int64, url string, secret string, eventMask int64, now int64) (w *Webhook, err error) {
+	w = &Webhook{
+		Developer: developer,
+		URL:       url,
+		Secret:    secret,
+		EventMask: eventMask,
+		CreatedAt: now,
+	}
+	err = db.Insert(w)
+	if err != nil {
+		err = errors.Wrapf(err, "add webhook failed")
+	}
+	return
+}
+
+func GetWebhook(db DB, developer int64, id int64) (w *Webhook, err error) {
+	err = db.SelectOne(&w, `SELECT * FROM "webhook" WHERE "id" = ? AND "developer_id" = ? LIMIT 1`, id, developer)
+	if err != nil {
+		err = errors.Wrapf(err, "get webhook failed")
+	}
+	return
+}
+
+func GetWebhooks(db DB, developer int64) (w []*Webhook, err error) {
+	_, err = db.Select(&w, `SELECT * FROM "webhook" WHERE "developer_id" = ?`, developer)
+	if err != nil {
+		err = errors.Wrapf(err, "get webhooks failed")
+	}
+	return
+}
+
+// GetWebhooksForEvent returns every webhook belonging to developer that's subscribed to event,
+// used by the dispatcher to fan an emitted event out to its subscribers.
+func GetWebhooksForEvent(db DB, developer int64, event int64) (w []*Webhook, err error) {
+	_, err = db.Select(&w,
+		`SELECT * FROM "webhook" WHERE "developer_id" = ? AND "event_mask" & ? != 0`,
+		developer, event)
+	if err != nil {
+		err = errors.Wrapf(err, "get webhooks for event failed")
+	}
+	return
+}
+
+func UpdateWebhook(db DB, developer int64, id int64, url string, secret string, eventMask int64) (err error) {
+	_, err = db.Exec(
+		`UPDATE "webhook" SET "url" = ?, "secret" = ?, "event_mask" = ? WHERE "id" = ? AND "developer_id" = ?`,
+		url, secret, eventMask, id, developer)
+	if err != nil {
+		err = errors.Wrapf(err, "update webhook failed")
+	}
+	return
+}
+
+func DeleteWebhook(db DB, developer int64, id int64) (err error) {
+	_, err = db.Exec(`DELETE FROM "webhook" WHERE "id" = ? AND "developer_id" = ?`, id, developer)
+	if err != nil {
+		err = errors.Wrapf(err, "delete webhook failed")
+	}
+	return
+}
+
+func AddWebhookDeadLetter(db DB, webhook int64, payload string, dispatchErr string, now int64) (err error) {
+	dl := &WebhookDeadLetter{
+		Webhook:    webhook,
+		Payload:    payload,
+		Error:      dispatchErr,
+		OccurredAt: now,
+	}
+	err = db.Insert(dl)
+	if err != nil {
+		err = errors.Wrapf(err, "add webhook dead letter failed")
+	}
+	return
+}
@@ -0,0 +1,172 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package webhook dispatches versioned event payloads to the webhook URLs developers register
+// via /api/webhooks, signing each delivery and retrying with backoff before giving up.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/cmd/cql-proxy/model"
+)
+
+const (
+	maxAttempts     = 5
+	initialBackoff  = 2 * time.Second
+	dispatchTimeout = 10 * time.Second
+	queueDepth      = 256
+	workerCount     = 16
+)
+
+// Payload is the versioned body posted to every subscribed webhook URL, signed over its raw
+// JSON bytes in the X-CQL-Signature header.
+type Payload struct {
+	Event        string      `json:"event"`
+	EventVersion int         `json:"event_version"`
+	OccurredAt   int64       `json:"occurred_at"`
+	Data         interface{} `json:"data"`
+}
+
+type job struct {
+	webhook *model.Webhook
+	payload Payload
+}
+
+// Dispatcher fans emitted events out to every subscribed model.Webhook. Deliveries are signed
+// with HMAC-SHA256 over the request body and retried with exponential backoff; a delivery that
+// still fails after maxAttempts is recorded as a model.WebhookDeadLetter instead of being lost.
+type Dispatcher struct {
+	db     model.DB
+	client *http.Client
+	queue  chan job
+}
+
+// NewDispatcher returns a Dispatcher backed by db. Call Start to begin processing deliveries.
+func NewDispatcher(db model.DB) *Dispatcher {
+	return &Dispatcher{
+		db:     db,
+		client: &http.Client{Timeout: dispatchTimeout},
+		queue:  make(chan job, queueDepth),
+	}
+}
+
+// Start runs workerCount dispatch workers until ctx is canceled. Each worker pulls jobs off
+// the shared queue independently, so one webhook stuck retrying through its backoff window
+// can't stall deliveries to every other developer's endpoints.
+func (d *Dispatcher) Start(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	for i := 0; i < workerCount; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.worker(ctx)
+		}()
+	}
+
+	wg.Wait()
+}
+
+func (d *Dispatcher) worker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case j := <-d.queue:
+			d.deliver(j)
+		}
+	}
+}
+
+// Emit looks up every webhook belonging to developer subscribed to event and queues a delivery
+// for each. It never blocks on network I/O; a full queue just drops the delivery rather than
+// stalling the caller, since callers are typically request handlers or task goroutines.
+func (d *Dispatcher) Emit(developer int64, event int64, name string, eventVersion int, data interface{}, now int64) {
+	hooks, err := model.GetWebhooksForEvent(d.db, developer, event)
+	if err != nil || len(hooks) == 0 {
+		return
+	}
+
+	payload := Payload{Event: name, EventVersion: eventVersion, OccurredAt: now, Data: data}
+
+	for _, w := range hooks {
+		select {
+		case d.queue <- job{webhook: w, payload: payload}:
+		default:
+		}
+	}
+}
+
+func (d *Dispatcher) deliver(j job) {
+	body, err := json.Marshal(j.payload)
+	if err != nil {
+		return
+	}
+
+	signature := sign(j.webhook.Secret, body)
+	backoff := initialBackoff
+
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if d.attempt(j.webhook.URL, signature, body) {
+			return
+		}
+
+		if attempt == maxAttempts {
+			break
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+
+	_ = model.AddWebhookDeadLetter(d.db, j.webhook.ID, string(body),
+		errors.Errorf("delivery failed after %d attempts", maxAttempts).Error(), j.payload.OccurredAt)
+}
+
+func (d *Dispatcher) attempt(url string, signature string, body []byte) bool {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-CQL-Signature", signature)
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+
+	return resp.StatusCode >= 200 && resp.StatusCode < 300
+}
+
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -64,11 +64,14 @@ type State struct {
 type Runtime struct {
 	sync.RWMutex // Protects following fields.
 
-	// Offset is the time difference calculated by: coodinatedChainTime - time.Now().
-	//
-	// TODO(leventeliu): update Offset in ping cycle.
+	// Offset is the time difference calculated by: coodinatedChainTime - time.Now(). It is kept
+	// current by a peer time-sync cycle (see timesync.go) running every Period/2.
 	Offset time.Duration
 
+	// synced reports whether the last time-sync round produced an offset that a quorum of
+	// peers agreed on. ProduceBlock refuses to run while it is false.
+	synced bool
+
 	// Period is the block producing cycle.
 	Period time.Duration
 
@@ -106,6 +109,27 @@ func (r *Runtime) SetNextTurn() {
 	r.NextTurn++
 }
 
+// setSynced records whether the last time-sync round reached quorum.
+func (r *Runtime) setSynced(synced bool) {
+	r.Lock()
+	defer r.Unlock()
+	r.synced = synced
+}
+
+// readOffset returns the current coordinated-time offset without advancing it, unlike Now.
+func (r *Runtime) readOffset() time.Duration {
+	r.RLock()
+	defer r.RUnlock()
+	return r.Offset
+}
+
+// isSynced reports whether the last time-sync round reached quorum.
+func (r *Runtime) isSynced() bool {
+	r.RLock()
+	defer r.RUnlock()
+	return r.synced
+}
+
 // Stop sends a signal to the Runtime stop channel by closing it.
 func (r *Runtime) Stop() {
 	close(r.stopCh)
@@ -157,10 +181,27 @@ type Chain struct {
 	rt    *Runtime
 	state *State
 
+	// syncer drives header-first fast sync against cfg.Peers. It stays nil for single-node
+	// chains that have no peers to sync against.
+	syncer *Syncer
+
+	// onReorg, if set, is notified every time PushBlock switches the main chain to a
+	// previously-side branch.
+	onReorg reorgHandler
+
+	// es lets in-process and RPC/WebSocket consumers subscribe to chain and query activity.
+	es *EventSystem
+
 	// Only for test
 	isMyTurn bool
 }
 
+// Events returns the chain's EventSystem, for subscribing to new blocks, acknowledged
+// queries, reorgs and expired queries.
+func (c *Chain) Events() *EventSystem {
+	return c.es
+}
+
 // NewChain creates a new sql-chain struct.
 func NewChain(cfg *Config) (chain *Chain, err error) {
 	err = cfg.Genesis.VerifyAsGenesis()
@@ -230,7 +271,9 @@ func NewChain(cfg *Config) (chain *Chain, err error) {
 			Head:   cfg.Genesis.SignedHeader.GenesisHash,
 			Height: -1,
 		},
+		es: NewEventSystem(),
 	}
+	chain.onReorg = chain.es.emitReorg
 
 	err = chain.PushBlock(cfg.Genesis)
 
@@ -238,6 +281,8 @@ func NewChain(cfg *Config) (chain *Chain, err error) {
 		return nil, err
 	}
 
+	chain.syncer = newChainSyncer(chain)
+
 	return
 }
 
@@ -281,7 +326,9 @@ func LoadChain(cfg *Config) (chain *Chain, err error) {
 			stopCh: make(chan struct{}),
 		},
 		state: &State{},
+		es:    NewEventSystem(),
 	}
+	chain.onReorg = chain.es.emitReorg
 
 	err = chain.db.View(func(tx *bolt.Tx) (err error) {
 		// Read state struct
@@ -375,46 +422,88 @@ func LoadChain(cfg *Config) (chain *Chain, err error) {
 		return
 	})
 
+	if err == nil {
+		chain.syncer = newChainSyncer(chain)
+	}
+
 	return
 }
 
-// PushBlock pushes the signed block header to extend the current main chain.
+// PushBlock indexes the signed block, possibly as a side branch, and switches the main chain
+// to it if it (or the branch it belongs to) now represents more accumulated work than the
+// current tip - reorganizing away from the old tip if necessary.
 func (c *Chain) PushBlock(b *ct.Block) (err error) {
 	// Prepare and encode
-	h := c.cfg.GetHeightFromTime(b.SignedHeader.Timestamp)
-	node := newBlockNode(b, c.state.node)
+	parent := c.bi.LookupNode(&b.SignedHeader.ParentHash)
+	node := newBlockNode(b, parent)
+	var encBlock []byte
+
+	if encBlock, err = b.MarshalBinary(); err != nil {
+		return
+	}
+
+	return c.db.Update(func(tx *bolt.Tx) (err error) {
+		if err = tx.Bucket(metaBucket[:]).Bucket(metaBlockIndexBucket).Put(
+			node.indexKey(), encBlock); err != nil {
+			return
+		}
+
+		c.bi.AddBlock(node)
+
+		if bestTip(c.state.node, node) != node {
+			// Still a shorter side branch: index it, but don't touch the main chain yet.
+			return nil
+		}
+
+		if c.state.node == nil || node.ancestor(c.state.node.height) == c.state.node {
+			// Common case: node simply extends the current main chain (or is the first block
+			// pushed onto an empty one).
+			return c.commitMainChain(tx, node)
+		}
+
+		return c.reorganize(tx, node)
+	})
+}
+
+// commitMainChain records node as the new main-chain tip, both in the persisted State and in
+// the in-memory QueryIndex.
+func (c *Chain) commitMainChain(tx *bolt.Tx, node *blockNode) (err error) {
 	state := &State{
 		node:   node,
 		Head:   node.hash,
 		Height: node.height,
 	}
-	var encBlock, encState []byte
 
-	if encBlock, err = b.MarshalBinary(); err != nil {
+	encState, err := state.MarshalBinary()
+
+	if err != nil {
 		return
 	}
 
-	if encState, err = state.MarshalBinary(); err != nil {
+	if err = tx.Bucket(metaBucket[:]).Put(metaStateKey, encState); err != nil {
 		return
 	}
 
-	// Update in transaction
-	return c.db.Update(func(tx *bolt.Tx) (err error) {
-		if err = tx.Bucket(metaBucket[:]).Put(metaStateKey, encState); err != nil {
-			return
-		}
+	b, err := c.fetchBlockTx(tx, node)
 
-		if err = tx.Bucket(metaBucket[:]).Bucket(metaBlockIndexBucket).Put(
-			node.indexKey(), encBlock); err != nil {
-			return
-		}
+	if err != nil {
+		return
+	}
 
-		c.state = state
-		c.bi.AddBlock(node)
-		c.qi.SetSignedBlock(h, b)
+	c.state = state
+	c.qi.SetSignedBlock(node.height, b)
+	c.es.emitNewBlock(node.height, node.hash)
+	return nil
+}
 
-		return
-	})
+// fetchBlockTx reads the full block body for node from the block index bucket using an
+// already-open transaction, so reorg/commit logic never has to open a second transaction
+// against the same database handle.
+func (c *Chain) fetchBlockTx(tx *bolt.Tx, node *blockNode) (b *ct.Block, err error) {
+	v := tx.Bucket(metaBucket[:]).Bucket(metaBlockIndexBucket).Get(node.indexKey())
+	b = &ct.Block{}
+	err = b.UnmarshalBinary(v)
+	return
 }
 
 func ensureHeight(tx *bolt.Tx, k []byte) (hb *bolt.Bucket, err error) {
@@ -465,7 +554,12 @@ func (c *Chain) PushResponedQuery(resp *wt.SignedResponseHeader) (err error) {
 		}
 
 		// Always put memory changes which will not be affected by rollback after DB operations
-		return c.qi.AddResponse(h, resp)
+		if err = c.qi.AddResponse(h, resp); err != nil {
+			return
+		}
+
+		c.es.emitRespondedQuery(h, resp.HeaderHash)
+		return nil
 	})
 }
 
@@ -502,32 +596,49 @@ func (c *Chain) PushAckedQuery(ack *wt.SignedAckHeader) (err error) {
 			c.rt.pendingBlock.PushAckedQuery(&ack.HeaderHash)
 		}
 
+		c.es.emitAckedQuery(h, ack.HeaderHash)
 		return
 	})
 }
 
-// CheckAndPushNewBlock implements ChainRPCServer.CheckAndPushNewBlock.
+// CheckAndPushNewBlock implements ChainRPCServer.CheckAndPushNewBlock. The pushed block no
+// longer needs to extend the current best chain directly: it may build on a side branch, as
+// long as its parent is already indexed. PushBlock takes care of reorganizing onto it if that
+// branch turns out to be the longest one.
 func (c *Chain) CheckAndPushNewBlock(block *ct.Block) (err error) {
-	// Pushed block must extend the best chain
-	if !block.SignedHeader.ParentHash.IsEqual(&c.state.Head) {
-		return ErrInvalidBlock
-	}
+	parent := c.bi.LookupNode(&block.SignedHeader.ParentHash)
 
-	// TODO(leventeliu): verify that block.SignedHeader.Producer is the rightful producer of the
-	// block.
+	if parent == nil {
+		return ErrParentNotFound
+	}
 
 	// Check block existence
 	if c.bi.HasBlock(&block.SignedHeader.BlockHash) {
 		return ErrBlockExists
 	}
 
-	// Block must produced within [start, end)
+	// Block must be produced within [start, end) of the height following its parent.
 	h := c.cfg.GetHeightFromTime(block.SignedHeader.Timestamp)
 
-	if h != c.state.Height+1 {
+	if h != parent.height+1 {
 		return ErrBlockTimestampOutOfPeriod
 	}
 
+	// Verify that block.SignedHeader.Producer is the rightful producer of the block, allowing
+	// for any view-change rounds that may have elapsed while waiting for it. The round only
+	// applies to the locally tracked pending height; blocks building on a side branch are
+	// checked against round 0, since the branch may yet become the main chain on its own
+	// schedule.
+	var round int32
+
+	if parent == c.state.node {
+		round = c.viewChangeRound()
+	}
+
+	if err = c.verifyProducer(h, &block.SignedHeader.ParentHash, round, block.SignedHeader.Producer); err != nil {
+		return
+	}
+
 	// Check queries
 	for _, q := range block.Queries {
 		var ok bool
@@ -570,6 +681,7 @@ func (c *Chain) queryTimeIsExpired(t time.Time) bool {
 func (c *Chain) VerifyAndPushResponsedQuery(resp *wt.SignedResponseHeader) (err error) {
 	// TODO(leventeliu): check resp.
 	if c.queryTimeIsExpired(resp.Timestamp) {
+		c.es.emitExpiredQuery(c.cfg.GetHeightFromTime(resp.Timestamp), resp.HeaderHash)
 		return ErrQueryExpired
 	}
 
@@ -584,6 +696,7 @@ func (c *Chain) VerifyAndPushResponsedQuery(resp *wt.SignedResponseHeader) (err
 func (c *Chain) VerifyAndPushAckedQuery(ack *wt.SignedAckHeader) (err error) {
 	// TODO(leventeliu): check ack.
 	if c.queryTimeIsExpired(ack.SignedResponseHeader().Timestamp) {
+		c.es.emitExpiredQuery(c.cfg.GetHeightFromTime(ack.SignedResponseHeader().Timestamp), ack.HeaderHash)
 		return ErrQueryExpired
 	}
 
@@ -594,15 +707,25 @@ func (c *Chain) VerifyAndPushAckedQuery(ack *wt.SignedAckHeader) (err error) {
 	return c.PushAckedQuery(ack)
 }
 
-// IsMyTurn returns whether it's my turn to produce block or not.
-//
-// TODO(leventliu): need implementation.
+// IsMyTurn returns whether it's my turn to produce block or not. It derives the rightful
+// producer for the pending height from the registered peer roster and the parent block hash,
+// so that the schedule is unpredictable ahead of time but verifiable by every peer once the
+// parent is known.
 func (c *Chain) IsMyTurn() bool {
-	return c.isMyTurn
+	if c.cfg.Peers == nil || len(c.cfg.Peers.Servers) == 0 {
+		// No roster configured: fall back to the test-only override.
+		return c.isMyTurn
+	}
+
+	return c.producerForPendingHeight() == c.cfg.Server.ID
 }
 
 // ProduceBlock prepares, signs and advises the pending block to the orther peers.
 func (c *Chain) ProduceBlock(parent hash.Hash, now time.Time) (err error) {
+	if !c.rt.isSynced() {
+		return ErrClockNotSynced
+	}
+
 	// TODO(leventeliu): remember to initialize local key store somewhere.
 	priv, err := kms.GetLocalPrivateKey()
 
@@ -638,6 +761,14 @@ func (c *Chain) RunCurrentTurn(now time.Time) {
 	}
 
 	if err := c.ProduceBlock(c.state.Head, now); err != nil {
+		if err == ErrClockNotSynced {
+			// Transient: time sync will retry on its own cycle, and SetNextTurn (deferred
+			// above) already advances us to the next slot, so just skip this turn instead of
+			// halting the chain over a single missed quorum.
+			log.WithError(err).Warn("skipping block production turn, clock not synced")
+			return
+		}
+
 		c.Stop()
 	}
 }
@@ -658,19 +789,16 @@ func (c *Chain) BlockProducingCycle() {
 	}
 }
 
-// Sync synchronizes blocks and queries from the other peers.
-//
-// TODO(leventeliu): need implementation.
-func (c *Chain) Sync() error {
-	return nil
-}
-
 // Start starts the main process of the sql-chain.
 func (c *Chain) Start() (err error) {
 	if err = c.Sync(); err != nil {
 		return
 	}
 
+	// Establish a coordinated chain time before producing any blocks, then keep it current.
+	c.syncTime()
+	go c.timeSyncCycle()
+
 	c.BlockProducingCycle()
 	return
 }
@@ -0,0 +1,234 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sqlchain
+
+import (
+	"sync"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+	"gitlab.com/thunderdb/ThunderDB/crypto/hash"
+)
+
+// EventType identifies the kind of activity an EventSystem subscriber is notified about.
+type EventType int
+
+const (
+	// EventNewBlock fires once a block has been committed as a direct extension of the main
+	// chain. Blocks connected by a reorg are reported in EventReorg's AddedBlocks instead.
+	EventNewBlock EventType = iota
+	// EventRespondedQuery fires once a query response has been recorded at a given height.
+	EventRespondedQuery
+	// EventAckedQuery fires once a query has been acknowledged at a given height.
+	EventAckedQuery
+	// EventReorg fires whenever the main chain switches to a previously-side branch.
+	EventReorg
+	// EventExpiredQuery fires when a query response or ack is rejected on arrival because it
+	// is already older than cfg.QueryTTL allows.
+	EventExpiredQuery
+)
+
+// Event is a single notification delivered to EventSystem subscribers. Only the fields
+// relevant to Type are populated.
+type Event struct {
+	Type EventType
+
+	// Height is the chain height the event pertains to.
+	Height int32
+
+	// BlockHash is set for EventNewBlock.
+	BlockHash hash.Hash
+
+	// QueryHash is set for EventRespondedQuery, EventAckedQuery and EventExpiredQuery.
+	QueryHash hash.Hash
+
+	// RemovedBlocks/AddedBlocks are set for EventReorg, ordered from the fork point towards
+	// their respective tip.
+	RemovedBlocks []hash.Hash
+	AddedBlocks   []hash.Hash
+}
+
+// EventFilter selects which Event Types a Subscription is interested in. A nil or empty
+// Types matches every event.
+type EventFilter struct {
+	Types []EventType
+}
+
+func (f EventFilter) matches(e Event) bool {
+	if len(f.Types) == 0 {
+		return true
+	}
+
+	for _, t := range f.Types {
+		if t == e.Type {
+			return true
+		}
+	}
+
+	return false
+}
+
+// subscriptionBacklog bounds how many unread events a single slow subscriber can accumulate
+// before new events are dropped for it rather than blocking the publisher.
+const subscriptionBacklog = 256
+
+// Subscription represents a live EventFilter registration returned by EventSystem.Subscribe.
+type Subscription struct {
+	id     uint64
+	filter EventFilter
+	ch     chan Event
+	es     *EventSystem
+	closed int32
+
+	// Dropped counts events that could not be delivered because ch was full, i.e. the
+	// subscriber was not reading fast enough.
+	Dropped uint64
+}
+
+// ID returns the subscription's unique identifier, used to Unsubscribe it later.
+func (s *Subscription) ID() uint64 {
+	return s.id
+}
+
+// Unsubscribe removes the subscription from its EventSystem and closes its channel. It is
+// safe to call more than once.
+func (s *Subscription) Unsubscribe() {
+	if !atomic.CompareAndSwapInt32(&s.closed, 0, 1) {
+		return
+	}
+
+	s.es.remove(s)
+	close(s.ch)
+}
+
+// EventSystem is a Go channel-based publish/subscribe hub for chain and query activity,
+// modeled on the filter/EventSystem used by go-ethereum's eth_subscribe. In-process
+// consumers, as well as RPC/WebSocket clients via NewEventWSHandler, subscribe with an
+// EventFilter and receive a dedicated channel of matching Events.
+type EventSystem struct {
+	mu     sync.RWMutex
+	subs   map[uint64]*Subscription
+	nextID uint64
+}
+
+// NewEventSystem creates an empty EventSystem.
+func NewEventSystem() *EventSystem {
+	return &EventSystem{subs: make(map[uint64]*Subscription)}
+}
+
+// Subscribe registers filter and returns a Subscription together with the channel that will
+// receive matching events until Unsubscribe is called.
+func (es *EventSystem) Subscribe(filter EventFilter) (*Subscription, <-chan Event) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	es.nextID++
+	sub := &Subscription{
+		id:     es.nextID,
+		filter: filter,
+		ch:     make(chan Event, subscriptionBacklog),
+		es:     es,
+	}
+	es.subs[sub.id] = sub
+
+	return sub, sub.ch
+}
+
+func (es *EventSystem) remove(sub *Subscription) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	delete(es.subs, sub.id)
+}
+
+// emit delivers e to every subscription whose filter matches it. A subscriber that isn't
+// draining its channel fast enough has the event dropped rather than blocking emit, with
+// Subscription.Dropped incremented so the gap is observable.
+func (es *EventSystem) emit(e Event) {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+
+	for _, sub := range es.subs {
+		if !sub.filter.matches(e) {
+			continue
+		}
+
+		select {
+		case sub.ch <- e:
+		default:
+			atomic.AddUint64(&sub.Dropped, 1)
+			log.WithField("subscription", sub.id).Warn("dropping event for slow subscriber")
+		}
+	}
+}
+
+// emitNewBlock publishes an EventNewBlock, tolerating a nil EventSystem so call sites don't
+// need to guard every emission.
+func (es *EventSystem) emitNewBlock(height int32, blockHash hash.Hash) {
+	if es == nil {
+		return
+	}
+
+	es.emit(Event{Type: EventNewBlock, Height: height, BlockHash: blockHash})
+}
+
+func (es *EventSystem) emitRespondedQuery(height int32, queryHash hash.Hash) {
+	if es == nil {
+		return
+	}
+
+	es.emit(Event{Type: EventRespondedQuery, Height: height, QueryHash: queryHash})
+}
+
+func (es *EventSystem) emitAckedQuery(height int32, queryHash hash.Hash) {
+	if es == nil {
+		return
+	}
+
+	es.emit(Event{Type: EventAckedQuery, Height: height, QueryHash: queryHash})
+}
+
+func (es *EventSystem) emitExpiredQuery(height int32, queryHash hash.Hash) {
+	if es == nil {
+		return
+	}
+
+	es.emit(Event{Type: EventExpiredQuery, Height: height, QueryHash: queryHash})
+}
+
+func (es *EventSystem) emitReorg(removed, added []*blockNode) {
+	if es == nil {
+		return
+	}
+
+	e := Event{Type: EventReorg}
+
+	if len(removed) > 0 {
+		e.Height = removed[0].height
+	} else if len(added) > 0 {
+		e.Height = added[0].height
+	}
+
+	for _, n := range removed {
+		e.RemovedBlocks = append(e.RemovedBlocks, n.hash)
+	}
+
+	for _, n := range added {
+		e.AddedBlocks = append(e.AddedBlocks, n.hash)
+	}
+
+	es.emit(e)
+}
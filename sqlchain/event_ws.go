@@ -0,0 +1,134 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sqlchain
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// wsSubscribeRequest mirrors the shape of an eth_subscribe JSON-RPC call: a method name and a
+// single parameter carrying the EventFilter.
+type wsSubscribeRequest struct {
+	ID     uint64      `json:"id"`
+	Method string      `json:"method"`
+	Params EventFilter `json:"params"`
+}
+
+// wsSubscribeResponse acknowledges a subscribe request with the Subscription ID future
+// notifications will be tagged with, analogous to an eth_subscribe result.
+type wsSubscribeResponse struct {
+	ID           uint64 `json:"id"`
+	Subscription uint64 `json:"subscription"`
+}
+
+// wsNotification wraps a single Event the way eth_subscribe wraps logs/heads: tagged with the
+// subscription it was delivered for.
+type wsNotification struct {
+	Subscription uint64 `json:"subscription"`
+	Result       Event  `json:"result"`
+}
+
+// NewEventWSHandler returns an http.Handler that upgrades to a WebSocket connection and speaks
+// a minimal eth_subscribe-style protocol against es: each inbound message subscribes a new
+// EventFilter, and every matching Event is pushed back tagged with its subscription ID until
+// the connection closes.
+func NewEventWSHandler(es *EventSystem) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+
+		if err != nil {
+			log.WithError(err).Warn("failed to upgrade event subscription connection")
+			return
+		}
+
+		defer conn.Close()
+
+		var subs []*Subscription
+		var forwarders sync.WaitGroup
+
+		// unsubscribeAll stops every forwarder goroutine and waits for each to actually exit
+		// before returning. Unsubscribe only closes the subscription's channel - it doesn't
+		// wait for the forwarder ranging over it to return, so a forwarder that already pulled
+		// an event off that channel can still be in the middle of its select against writeCh
+		// concurrently with a close(writeCh) that follows. A send on a closed channel panics
+		// even under a select with a default case, so writeCh must not be closed until every
+		// forwarder has actually returned, not just been asked to.
+		unsubscribeAll := func() {
+			for _, sub := range subs {
+				sub.Unsubscribe()
+			}
+			forwarders.Wait()
+		}
+		defer unsubscribeAll()
+
+		writeCh := make(chan wsNotification, subscriptionBacklog)
+		done := make(chan struct{})
+
+		go func() {
+			defer close(done)
+
+			for n := range writeCh {
+				if err := conn.WriteJSON(n); err != nil {
+					return
+				}
+			}
+		}()
+
+		for {
+			var req wsSubscribeRequest
+
+			if err := conn.ReadJSON(&req); err != nil {
+				unsubscribeAll()
+				close(writeCh)
+				<-done
+				return
+			}
+
+			sub, ch := es.Subscribe(req.Params)
+			subs = append(subs, sub)
+
+			if err := conn.WriteJSON(wsSubscribeResponse{ID: req.ID, Subscription: sub.ID()}); err != nil {
+				unsubscribeAll()
+				close(writeCh)
+				<-done
+				return
+			}
+
+			forwarders.Add(1)
+			go func(sub *Subscription, ch <-chan Event) {
+				defer forwarders.Done()
+
+				for e := range ch {
+					select {
+					case writeCh <- wsNotification{Subscription: sub.ID(), Result: e}:
+					default:
+						log.WithField("subscription", sub.ID()).Warn("dropping notification for slow websocket writer")
+					}
+				}
+			}(sub, ch)
+		}
+	})
+}
@@ -0,0 +1,101 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sqlchain
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"gitlab.com/thunderdb/ThunderDB/crypto/hash"
+	"gitlab.com/thunderdb/ThunderDB/proto"
+)
+
+// ErrInvalidProducer indicates that a pushed block's producer field does not match the
+// deterministically derived producer for its height and view-change round.
+var ErrInvalidProducer = errors.New("sqlchain: invalid block producer")
+
+// producerSeed derives a pseudo-random seed for producer selection from a parent block hash,
+// so that the rotation order cannot be predicted before the parent block is known, while
+// remaining fully verifiable by anyone who has it.
+func producerSeed(parent *hash.Hash) uint64 {
+	return binary.BigEndian.Uint64(parent[:8])
+}
+
+// producerAtRound returns the node ID scheduled to produce the block at height h, assuming
+// view-change round round has already elapsed (round 0 being the regular, uncontested
+// schedule). Peers rotate round-robin over the ordered roster, offset by both height and a
+// seed derived from the parent hash, and further offset by round so that a peer who misses
+// its slot hands eligibility to the next peer in the ring.
+func producerAtRound(peers []proto.NodeID, h int32, parent *hash.Hash, round int32) proto.NodeID {
+	if len(peers) == 0 {
+		return ""
+	}
+
+	seed := producerSeed(parent)
+	idx := (uint64(h) + seed + uint64(round)) % uint64(len(peers))
+	return peers[idx]
+}
+
+// verifyProducer checks that producer is the rightful producer of the block at height h
+// building on parentHash, allowing for round elapsed view-change rounds. It's a no-op when no
+// peer roster is configured (single-node chains). Shared by CheckAndPushNewBlock, which knows
+// round from its own view-change tracking, and Syncer.Sync, which always checks round 0 since
+// a syncing node has no local view-change state for blocks it didn't produce itself.
+func (c *Chain) verifyProducer(h int32, parentHash *hash.Hash, round int32, producer proto.NodeID) error {
+	peers := c.cfg.Peers
+	if peers == nil || len(peers.Servers) == 0 {
+		return nil
+	}
+
+	if expected := producerAtRound(peers.Servers, h, parentHash, round); producer != expected {
+		return ErrInvalidProducer
+	}
+
+	return nil
+}
+
+// pendingHeight returns the height of the block the chain is currently trying to produce.
+func (c *Chain) pendingHeight() int32 {
+	return c.state.Height + 1
+}
+
+// viewChangeRound reports how many producer rotations have been skipped for the currently
+// pending height. Runtime.NextTurn advances once per period regardless of whether a block was
+// actually produced, so in the common case it stays in lock-step with pendingHeight(). If the
+// elected producer misses its slot, the gap between the two grows; once it reaches
+// cfg.ViewChangeK periods, eligibility rotates to the next peer in the ring.
+func (c *Chain) viewChangeRound() int32 {
+	k := c.cfg.ViewChangeK
+
+	if k <= 0 {
+		k = 1
+	}
+
+	gap := c.rt.NextTurn - c.pendingHeight()
+
+	if gap <= 0 {
+		return 0
+	}
+
+	return gap / k
+}
+
+// producerForPendingHeight returns the node currently eligible to produce the pending block,
+// taking any elapsed view-change rounds into account.
+func (c *Chain) producerForPendingHeight() proto.NodeID {
+	return producerAtRound(c.cfg.Peers.Servers, c.pendingHeight(), &c.state.Head, c.viewChangeRound())
+}
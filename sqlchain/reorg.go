@@ -0,0 +1,143 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sqlchain
+
+import (
+	bolt "github.com/coreos/bbolt"
+)
+
+// reorgHandler, if set, is notified whenever PushBlock switches the main chain to a
+// previously-side branch. It receives the disconnected and newly-connected blocks, both
+// ordered from the fork point towards the respective tip.
+type reorgHandler func(removed, added []*blockNode)
+
+// commonAncestor walks both nodes back to the same height, then back together, to find the
+// last blockNode they share. It relies only on blockNode.ancestor, so it works regardless of
+// how deep the two branches have diverged.
+func commonAncestor(a, b *blockNode) *blockNode {
+	for a != nil && b != nil && a.height > b.height {
+		a = a.ancestor(b.height)
+	}
+
+	for a != nil && b != nil && b.height > a.height {
+		b = b.ancestor(a.height)
+	}
+
+	for a != nil && b != nil && !a.hash.IsEqual(&b.hash) {
+		a = a.ancestor(a.height - 1)
+		b = b.ancestor(b.height - 1)
+	}
+
+	if a == nil || b == nil {
+		return nil
+	}
+
+	return a
+}
+
+// branchNodes walks from tip back to (but not including) ancestor, returning the nodes
+// ordered from the fork point towards tip.
+func branchNodes(tip, ancestor *blockNode) (nodes []*blockNode) {
+	for n := tip; n != nil && n != ancestor; n = n.ancestor(n.height - 1) {
+		nodes = append([]*blockNode{n}, nodes...)
+	}
+
+	return
+}
+
+// reorganize switches the main chain from the current tip to newTip, which must already be
+// indexed. It undoes the QueryIndex mutations applied by every disconnected block (using each
+// block's own appliedAcks, i.e. its Queries list) and replays the acks recorded in every newly
+// connected block, so that c.qi ends up reflecting exactly the new main chain.
+func (c *Chain) reorganize(tx *bolt.Tx, newTip *blockNode) (err error) {
+	oldTip := c.state.node
+	fork := commonAncestor(oldTip, newTip)
+
+	removed := branchNodes(oldTip, fork)
+	added := branchNodes(newTip, fork)
+
+	// Undo disconnected blocks from the current tip back towards the fork point, newest first,
+	// so that a query acknowledged in two disconnected blocks is only ever unwound once.
+	for i := len(removed) - 1; i >= 0; i-- {
+		n := removed[i]
+		b, e := c.fetchBlockTx(tx, n)
+
+		if e != nil {
+			return e
+		}
+
+		for _, q := range b.Queries {
+			if e = c.qi.RemoveAck(n.height, q); e != nil {
+				return e
+			}
+		}
+	}
+
+	// Replay newly connected blocks from the fork point towards the new tip.
+	for _, n := range added {
+		b, e := c.fetchBlockTx(tx, n)
+
+		if e != nil {
+			return e
+		}
+
+		c.qi.SetSignedBlock(n.height, b)
+	}
+
+	state := &State{
+		node:   newTip,
+		Head:   newTip.hash,
+		Height: newTip.height,
+	}
+
+	encState, err := state.MarshalBinary()
+
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Bucket(metaBucket[:]).Put(metaStateKey, encState); err != nil {
+		return err
+	}
+
+	c.state = state
+
+	if c.onReorg != nil {
+		c.onReorg(removed, added)
+	}
+
+	return nil
+}
+
+// bestTip returns whichever of a and b represents more work, i.e. the greater height - round-
+// robin BFT chains have exactly one valid block per height, so height is an unambiguous proxy
+// for accumulated work.
+func bestTip(a, b *blockNode) *blockNode {
+	if a == nil {
+		return b
+	}
+
+	if b == nil {
+		return a
+	}
+
+	if b.height > a.height {
+		return b
+	}
+
+	return a
+}
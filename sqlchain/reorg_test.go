@@ -0,0 +1,221 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sqlchain
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	bolt "github.com/coreos/bbolt"
+	ct "gitlab.com/thunderdb/ThunderDB/sqlchain/types"
+)
+
+// buildBranch extends parent with n freshly-minted blocks and returns the resulting tip node.
+// Each block's timestamp is distinct so that distinct branches never collide on block hash.
+func buildBranch(parent *blockNode, n int, salt byte) *blockNode {
+	tip := parent
+
+	for i := 0; i < n; i++ {
+		b := &ct.Block{
+			SignedHeader: ct.SignedHeader{
+				Header: ct.Header{
+					Timestamp: time.Unix(int64(salt)*1000+int64(i), 0),
+				},
+			},
+		}
+
+		if tip != nil {
+			b.SignedHeader.ParentHash = tip.hash
+		}
+
+		tip = newBlockNode(b, tip)
+	}
+
+	return tip
+}
+
+func testForkAtDepth(t *testing.T, removedDepth, addedDepth int) {
+	genesis := buildBranch(nil, 1, 0)
+
+	oldTip := buildBranch(genesis, removedDepth, 1)
+	newTip := buildBranch(genesis, addedDepth, 2)
+
+	fork := commonAncestor(oldTip, newTip)
+
+	if fork == nil || !fork.hash.IsEqual(&genesis.hash) {
+		t.Fatalf("removed=%d added=%d: expected fork point at genesis", removedDepth, addedDepth)
+	}
+
+	removed := branchNodes(oldTip, fork)
+	added := branchNodes(newTip, fork)
+
+	if len(removed) != removedDepth {
+		t.Fatalf("removed=%d added=%d: expected %d removed nodes, got %d",
+			removedDepth, addedDepth, removedDepth, len(removed))
+	}
+
+	if len(added) != addedDepth {
+		t.Fatalf("removed=%d added=%d: expected %d added nodes, got %d",
+			removedDepth, addedDepth, addedDepth, len(added))
+	}
+
+	if len(removed) > 0 && removed[len(removed)-1] != oldTip {
+		t.Fatalf("removed=%d added=%d: removed nodes should end at the old tip", removedDepth, addedDepth)
+	}
+
+	if len(added) > 0 && added[len(added)-1] != newTip {
+		t.Fatalf("removed=%d added=%d: added nodes should end at the new tip", removedDepth, addedDepth)
+	}
+
+	if bestTip(oldTip, newTip) != newTip {
+		t.Fatalf("removed=%d added=%d: expected the longer branch to win", removedDepth, addedDepth)
+	}
+}
+
+// TestReorgAtVariousDepths covers competing forks of depth 1, 3 and 10, checking that the
+// common ancestor and the set of disconnected/connected blocks are computed correctly in each
+// case.
+func TestReorgAtVariousDepths(t *testing.T) {
+	for _, depth := range []int{1, 3, 10} {
+		testForkAtDepth(t, depth, depth+1)
+	}
+}
+
+// buildBranchBlocks is buildBranch, but also returns the underlying blocks in height order, so
+// a caller can push them through a real Chain via PushBlock instead of only inspecting the
+// resulting blockNode chain.
+func buildBranchBlocks(parent *blockNode, n int, salt byte) (tip *blockNode, blocks []*ct.Block) {
+	tip = parent
+
+	for i := 0; i < n; i++ {
+		b := &ct.Block{
+			SignedHeader: ct.SignedHeader{
+				Header: ct.Header{
+					Timestamp: time.Unix(int64(salt)*1000+int64(i), 0),
+				},
+			},
+		}
+
+		if tip != nil {
+			b.SignedHeader.ParentHash = tip.hash
+		}
+
+		tip = newBlockNode(b, tip)
+		blocks = append(blocks, b)
+	}
+
+	return
+}
+
+// newTestChain returns a Chain backed by a fresh, real on-disk bolt DB, bypassing NewChain's
+// genesis-signature and key-store setup (irrelevant to reorg bookkeeping). The returned func
+// removes the underlying DB file once the test is done with it.
+func newTestChain(t *testing.T) (c *Chain, cleanup func()) {
+	f, err := ioutil.TempFile("", "sqlchain-reorg-test-")
+	if err != nil {
+		t.Fatalf("create temp db file: %v", err)
+	}
+	f.Close()
+
+	db, err := bolt.Open(f.Name(), 0600, nil)
+	if err != nil {
+		t.Fatalf("open temp db: %v", err)
+	}
+
+	if err = db.Update(func(tx *bolt.Tx) (err error) {
+		bucket, err := tx.CreateBucketIfNotExists(metaBucket[:])
+		if err != nil {
+			return err
+		}
+
+		_, err = bucket.CreateBucketIfNotExists(metaBlockIndexBucket)
+		return err
+	}); err != nil {
+		t.Fatalf("create buckets: %v", err)
+	}
+
+	cfg := &Config{}
+	c = &Chain{
+		cfg:   cfg,
+		db:    db,
+		bi:    newBlockIndex(cfg),
+		qi:    NewQueryIndex(),
+		state: &State{Height: -1},
+		es:    NewEventSystem(),
+	}
+	c.onReorg = c.es.emitReorg
+
+	return c, func() {
+		db.Close()
+		os.Remove(f.Name())
+	}
+}
+
+// TestReorganizePushBlock pushes a real fork through Chain.PushBlock against a real bolt DB and
+// checks that once the longer branch overtakes the shorter one, reorganize() leaves the chain
+// pointed at the new tip and FetchBlock resolving every contested height to the winning branch's
+// blocks rather than the abandoned ones.
+func TestReorganizePushBlock(t *testing.T) {
+	c, cleanup := newTestChain(t)
+	defer cleanup()
+
+	genesisTip, genesisBlocks := buildBranchBlocks(nil, 1, 0)
+	if err := c.PushBlock(genesisBlocks[0]); err != nil {
+		t.Fatalf("push genesis: %v", err)
+	}
+
+	_, shortBranch := buildBranchBlocks(genesisTip, 2, 1)
+	for _, b := range shortBranch {
+		if err := c.PushBlock(b); err != nil {
+			t.Fatalf("push short branch block: %v", err)
+		}
+	}
+
+	if c.state.Height != int32(len(shortBranch)) {
+		t.Fatalf("expected short branch to be the main chain at height %d, got %d",
+			len(shortBranch), c.state.Height)
+	}
+
+	longTip, longBranch := buildBranchBlocks(genesisTip, 3, 2)
+	for _, b := range longBranch {
+		if err := c.PushBlock(b); err != nil {
+			t.Fatalf("push long branch block: %v", err)
+		}
+	}
+
+	if c.state.Height != longTip.height {
+		t.Fatalf("expected reorg to the longer branch at height %d, got %d",
+			longTip.height, c.state.Height)
+	}
+
+	if !c.state.Head.IsEqual(&longTip.hash) {
+		t.Fatalf("expected chain head to be the long branch's tip after reorg")
+	}
+
+	for i, want := range longBranch {
+		got, err := c.FetchBlock(int32(i) + 1)
+		if err != nil {
+			t.Fatalf("fetch block at height %d: %v", i+1, err)
+		}
+
+		if !got.SignedHeader.Timestamp.Equal(want.SignedHeader.Timestamp) {
+			t.Fatalf("height %d: expected the winning branch's block, got a stale one from the abandoned branch", i+1)
+		}
+	}
+}
@@ -0,0 +1,378 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sqlchain
+
+import (
+	"errors"
+
+	log "github.com/sirupsen/logrus"
+	"gitlab.com/thunderdb/ThunderDB/crypto/hash"
+	"gitlab.com/thunderdb/ThunderDB/proto"
+	"gitlab.com/thunderdb/ThunderDB/rpc"
+	ct "gitlab.com/thunderdb/ThunderDB/sqlchain/types"
+	wt "gitlab.com/thunderdb/ThunderDB/worker/types"
+)
+
+// ErrNoSyncPeer indicates that no peer could offer a chain longer than the local one.
+var ErrNoSyncPeer = errors.New("sqlchain: no usable sync peer")
+
+// headerFetchBatch is the number of headers requested per FetchHeaders round trip.
+const headerFetchBatch = 128
+
+// fastSyncTrustWindow is how many blocks below the peer's reported tip are still fully
+// verified even in SyncModeFast - only blocks older than that, which the rest of the network
+// has had time to challenge via reorg, skip re-verification. Syncer.Sync recomputes Pivot from
+// this every round, so it tracks the peer's tip instead of staying at its zero value forever.
+const fastSyncTrustWindow = 1024
+
+// SyncMode selects how aggressively a Syncer verifies history while catching up.
+type SyncMode int
+
+const (
+	// SyncModeFast stores blocks and query acks older than the pivot height without
+	// re-verifying every signature, trading verification time for catch-up speed.
+	SyncModeFast SyncMode = iota
+	// SyncModeFull verifies every header, block and query ack it downloads.
+	SyncModeFull
+)
+
+// FetchHeadersReq is the request payload for ChainRPCServer.FetchHeaders.
+type FetchHeadersReq struct {
+	FromHeight int32
+	Count      int32
+}
+
+// FetchHeadersResp is the response payload for ChainRPCServer.FetchHeaders.
+type FetchHeadersResp struct {
+	Headers []*ct.SignedHeader
+}
+
+// FetchBlockReq is the request payload for ChainRPCServer.FetchBlockByHash.
+type FetchBlockReq struct {
+	Hash hash.Hash
+}
+
+// FetchBlockResp is the response payload for ChainRPCServer.FetchBlockByHash.
+type FetchBlockResp struct {
+	Block *ct.Block
+}
+
+// FetchQueriesAtHeightReq is the request payload for ChainRPCServer.FetchQueriesAtHeight.
+type FetchQueriesAtHeightReq struct {
+	Height int32
+}
+
+// FetchQueriesAtHeightResp is the response payload for ChainRPCServer.FetchQueriesAtHeight.
+type FetchQueriesAtHeightResp struct {
+	Responses []*wt.SignedResponseHeader
+	Acks      []*wt.SignedAckHeader
+}
+
+// FetchHeaders implements ChainRPCServer.FetchHeaders: it returns up to req.Count consecutive
+// signed headers starting at req.FromHeight from local storage, for header-first fast sync.
+func (c *Chain) FetchHeaders(req *FetchHeadersReq, resp *FetchHeadersResp) (err error) {
+	for h := req.FromHeight; h < req.FromHeight+req.Count; h++ {
+		b, err := c.FetchBlock(h)
+
+		if err != nil || b == nil {
+			break
+		}
+
+		resp.Headers = append(resp.Headers, &b.SignedHeader)
+	}
+
+	return nil
+}
+
+// FetchBlockByHash implements ChainRPCServer.FetchBlockByHash: it returns the full block body
+// identified by req.Hash from local storage, for the body-fetching phase of fast sync.
+func (c *Chain) FetchBlockByHash(req *FetchBlockReq, resp *FetchBlockResp) (err error) {
+	n := c.bi.LookupNode(&req.Hash)
+
+	if n == nil {
+		return ErrParentNotFound
+	}
+
+	resp.Block, err = c.FetchBlock(n.height)
+	return
+}
+
+// FetchQueriesAtHeight implements ChainRPCServer.FetchQueriesAtHeight: it returns every
+// response and ack header recorded at the given height, for replay by a syncing peer.
+func (c *Chain) FetchQueriesAtHeight(req *FetchQueriesAtHeightReq, resp *FetchQueriesAtHeightResp) (err error) {
+	resp.Responses, resp.Acks = c.qi.GetAllAtHeight(req.Height)
+	return nil
+}
+
+// syncPeer is the minimal RPC surface a Syncer needs to reach another node over the existing
+// ETLS RPC transport.
+type syncPeer interface {
+	NodeID() proto.NodeID
+	RequestState() (*State, error)
+	FetchHeaders(req *FetchHeadersReq, resp *FetchHeadersResp) error
+	FetchBlockByHash(req *FetchBlockReq, resp *FetchBlockResp) error
+	FetchQueriesAtHeight(req *FetchQueriesAtHeightReq, resp *FetchQueriesAtHeightResp) error
+}
+
+// Syncer drives header-first fast synchronization of a Chain against its configured peers,
+// modeled on the header-first fast-sync mode used by go-ethereum: headers are downloaded and
+// verified first to establish the best verified chain, and only then are full bodies and
+// query acks pulled in and replayed.
+type Syncer struct {
+	c     *Chain
+	peers []syncPeer
+	mode  SyncMode
+
+	// Pivot is the height below which block bodies and query acks are trusted without a full
+	// signature re-verification pass, when running in SyncModeFast. Sync recomputes it every
+	// round as peerState.Height - fastSyncTrustWindow, so it isn't meaningful until Sync has
+	// run at least once.
+	Pivot int32
+
+	// LastVerifiedHeight starts at the chain's persisted State height, so a restarted node
+	// resumes fast-sync from its last committed block instead of re-downloading from genesis.
+	LastVerifiedHeight int32
+}
+
+// NewSyncer creates a Syncer for chain c using the given peers and sync mode.
+func NewSyncer(c *Chain, peers []syncPeer, mode SyncMode) *Syncer {
+	return &Syncer{
+		c:                  c,
+		peers:              peers,
+		mode:               mode,
+		LastVerifiedHeight: c.state.Height,
+	}
+}
+
+// newChainSyncer builds the Syncer used by Chain.Sync from the chain's own peer roster,
+// skipping the local node itself. It returns nil when there are no other peers to sync
+// against, so Chain.Sync can treat single-node chains as already up to date.
+func newChainSyncer(c *Chain) *Syncer {
+	if c.cfg.Peers == nil {
+		return nil
+	}
+
+	var peers []syncPeer
+
+	for _, id := range c.cfg.Peers.Servers {
+		if id == c.cfg.Server.ID {
+			continue
+		}
+
+		peers = append(peers, newRPCSyncPeer(id))
+	}
+
+	if len(peers) == 0 {
+		return nil
+	}
+
+	return NewSyncer(c, peers, SyncModeFast)
+}
+
+// bestPeer queries every configured peer for its best-chain State and returns the peer
+// reporting the longest verified head, or ErrNoSyncPeer if none is ahead of the local chain.
+func (s *Syncer) bestPeer() (best syncPeer, bestState *State, err error) {
+	for _, p := range s.peers {
+		st, e := p.RequestState()
+
+		if e != nil {
+			log.WithError(e).WithField("peer", p.NodeID()).Warn("failed to query peer chain state")
+			continue
+		}
+
+		if st.Height <= s.c.state.Height {
+			continue
+		}
+
+		if bestState == nil || st.Height > bestState.Height {
+			best, bestState = p, st
+		}
+	}
+
+	if best == nil {
+		return nil, nil, ErrNoSyncPeer
+	}
+
+	return
+}
+
+// fetchHeaderChain downloads signed headers in headerFetchBatch-sized batches from from up to
+// and including to, verifying each header's signature and its linkage to the previous one,
+// into a temporary in-memory index. It returns the height of the last common ancestor found
+// with the local chain.
+func (s *Syncer) fetchHeaderChain(peer syncPeer, from, to int32) (headers []*ct.SignedHeader, commonAncestor int32, err error) {
+	commonAncestor = -1
+	var prev *ct.SignedHeader
+
+	for h := from; h <= to; h += headerFetchBatch {
+		count := headerFetchBatch
+
+		if h+int32(count) > to+1 {
+			count = int(to + 1 - h)
+		}
+
+		var resp FetchHeadersResp
+
+		if err = peer.FetchHeaders(&FetchHeadersReq{FromHeight: h, Count: int32(count)}, &resp); err != nil {
+			return
+		}
+
+		for _, hdr := range resp.Headers {
+			if prev != nil && !hdr.ParentHash.IsEqual(&prev.BlockHash) {
+				return nil, commonAncestor, ErrInvalidBlock
+			}
+
+			if err = hdr.Verify(); err != nil {
+				return
+			}
+
+			if local, e := s.c.FetchBlock(s.c.cfg.GetHeightFromTime(hdr.Timestamp)); e == nil && local != nil &&
+				local.SignedHeader.BlockHash.IsEqual(&hdr.BlockHash) {
+				commonAncestor = s.c.cfg.GetHeightFromTime(hdr.Timestamp)
+			}
+
+			headers = append(headers, hdr)
+			prev = hdr
+		}
+	}
+
+	return
+}
+
+// Sync runs one round of header-first fast synchronization: it picks the best available peer,
+// downloads and verifies its headers down to the common ancestor with the local chain, then
+// pivots to downloading full blocks and query acks for every new height and replays them
+// in order via PushBlock/PushResponedQuery/PushAckedQuery.
+func (s *Syncer) Sync() (err error) {
+	peer, peerState, err := s.bestPeer()
+
+	if err != nil {
+		if err == ErrNoSyncPeer {
+			// Already at or ahead of every peer: nothing to do.
+			return nil
+		}
+
+		return err
+	}
+
+	headers, _, err := s.fetchHeaderChain(peer, s.LastVerifiedHeight+1, peerState.Height)
+
+	if err != nil {
+		return err
+	}
+
+	if s.mode == SyncModeFast {
+		s.Pivot = peerState.Height - fastSyncTrustWindow
+	}
+
+	for _, hdr := range headers {
+		h := s.c.cfg.GetHeightFromTime(hdr.Timestamp)
+		fast := s.mode == SyncModeFast && h < s.Pivot
+
+		if err = s.c.verifyProducer(h, &hdr.ParentHash, 0, hdr.Producer); err != nil {
+			return err
+		}
+
+		var blockResp FetchBlockResp
+
+		if err = peer.FetchBlockByHash(&FetchBlockReq{Hash: hdr.BlockHash}, &blockResp); err != nil {
+			return err
+		}
+
+		if !fast {
+			if err = blockResp.Block.Verify(); err != nil {
+				return err
+			}
+		}
+
+		var queries FetchQueriesAtHeightResp
+
+		if err = peer.FetchQueriesAtHeight(&FetchQueriesAtHeightReq{Height: h}, &queries); err != nil {
+			return err
+		}
+
+		for _, resp := range queries.Responses {
+			if fast {
+				if err = s.c.PushResponedQuery(resp); err != nil {
+					return err
+				}
+			} else if err = s.c.VerifyAndPushResponsedQuery(resp); err != nil {
+				return err
+			}
+		}
+
+		for _, ack := range queries.Acks {
+			if fast {
+				if err = s.c.PushAckedQuery(ack); err != nil {
+					return err
+				}
+			} else if err = s.c.VerifyAndPushAckedQuery(ack); err != nil {
+				return err
+			}
+		}
+
+		if err = s.c.PushBlock(blockResp.Block); err != nil {
+			return err
+		}
+
+		s.LastVerifiedHeight = h
+	}
+
+	return nil
+}
+
+// rpcSyncPeer implements syncPeer over the existing ETLS RPC transport, calling into the
+// remote node's own ChainRPCServer implementation.
+type rpcSyncPeer struct {
+	id     proto.NodeID
+	caller *rpc.PersistentCaller
+}
+
+func newRPCSyncPeer(id proto.NodeID) *rpcSyncPeer {
+	return &rpcSyncPeer{id: id, caller: rpc.NewPersistentCaller(id)}
+}
+
+func (p *rpcSyncPeer) NodeID() proto.NodeID { return p.id }
+
+func (p *rpcSyncPeer) RequestState() (st *State, err error) {
+	st = &State{}
+	err = p.caller.Call("SQLC.State", &struct{}{}, st)
+	return
+}
+
+func (p *rpcSyncPeer) FetchHeaders(req *FetchHeadersReq, resp *FetchHeadersResp) error {
+	return p.caller.Call("SQLC.FetchHeaders", req, resp)
+}
+
+func (p *rpcSyncPeer) FetchBlockByHash(req *FetchBlockReq, resp *FetchBlockResp) error {
+	return p.caller.Call("SQLC.FetchBlockByHash", req, resp)
+}
+
+func (p *rpcSyncPeer) FetchQueriesAtHeight(req *FetchQueriesAtHeightReq, resp *FetchQueriesAtHeightResp) error {
+	return p.caller.Call("SQLC.FetchQueriesAtHeight", req, resp)
+}
+
+// Sync synchronizes blocks and queries from the other peers, using header-first fast sync to
+// catch up to the best known chain before the regular block producing cycle starts.
+func (c *Chain) Sync() error {
+	if c.syncer == nil {
+		// No peers configured to sync against: start from genesis as a single-node chain.
+		return nil
+	}
+
+	return c.syncer.Sync()
+}
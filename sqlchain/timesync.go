@@ -0,0 +1,206 @@
+/*
+ * Copyright 2018 The ThunderDB Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sqlchain
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gitlab.com/thunderdb/ThunderDB/proto"
+)
+
+// ErrClockNotSynced indicates that the node could not agree on a coordinated chain time with
+// enough peers, and must refuse to produce blocks until it does.
+var ErrClockNotSynced = errors.New("sqlchain: local clock is not synced with enough peers")
+
+// TimePing is sent by the initiator of a time-sync probe, carrying only the time it was sent.
+type TimePing struct {
+	T1 time.Time
+}
+
+// TimePong is the responder's reply to a TimePing: T1 is echoed back, T2 is when the ping was
+// received and T3 is when the pong was sent - T2 and T3 are equal unless the responder does
+// non-trivial work before replying.
+type TimePong struct {
+	T1 time.Time
+	T2 time.Time
+	T3 time.Time
+}
+
+// Ping implements ChainRPCServer.Ping: it timestamps the incoming probe and echoes it back so
+// the initiator can compute clock offset and round-trip delay.
+func (c *Chain) Ping(req *TimePing, resp *TimePong) (err error) {
+	now := time.Now()
+	resp.T1 = req.T1
+	resp.T2 = now
+	resp.T3 = now
+	return nil
+}
+
+// timeSample is one peer's contribution to a time-sync round.
+type timeSample struct {
+	peer   proto.NodeID
+	offset time.Duration
+	delay  time.Duration
+}
+
+// timePeer is the minimal RPC surface a time-sync round needs.
+type timePeer interface {
+	NodeID() proto.NodeID
+	Ping(req *TimePing, resp *TimePong) error
+}
+
+func (p *rpcSyncPeer) Ping(req *TimePing, resp *TimePong) error {
+	return p.caller.Call("SQLC.Ping", req, resp)
+}
+
+// sampleOffset runs a single NTP-style probe against peer, returning the clock offset
+// ((t2-t1)+(t3-t4))/2 and round-trip delay (t4-t1)-(t3-t2).
+func sampleOffset(peer timePeer) (s timeSample, err error) {
+	t1 := time.Now()
+	var pong TimePong
+
+	if err = peer.Ping(&TimePing{T1: t1}, &pong); err != nil {
+		return
+	}
+
+	t4 := time.Now()
+	s.peer = peer.NodeID()
+	s.offset = ((pong.T2.Sub(t1)) + (pong.T3.Sub(t4))) / 2
+	s.delay = t4.Sub(t1) - pong.T3.Sub(pong.T2)
+	return
+}
+
+// medianDuration returns the median of a non-empty, already-sorted-by-caller-irrelevant slice
+// of durations.
+func medianDuration(ds []time.Duration) time.Duration {
+	sorted := append([]time.Duration(nil), ds...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	mid := len(sorted) / 2
+
+	if len(sorted)%2 == 1 {
+		return sorted[mid]
+	}
+
+	return (sorted[mid-1] + sorted[mid]) / 2
+}
+
+// syncTime runs one round of peer time synchronization: it probes every configured peer,
+// discards samples whose round-trip delay exceeds twice the median delay (likely congested or
+// misbehaving links), and sets Runtime.Offset to the median offset of the survivors. If the
+// newly computed offset differs from the current one by more than cfg.MaxAcceptableSkew, or
+// fewer than f+1 peers survive filtering, it logs a warning and marks the clock unsynced so
+// ProduceBlock refuses to run until a later round succeeds.
+func (c *Chain) syncTime() {
+	if c.syncer == nil {
+		// Single-node chain: nothing to synchronize against, so trust the local clock.
+		c.rt.setSynced(true)
+		return
+	}
+
+	peers := make([]timePeer, 0, len(c.syncer.peers))
+
+	for _, p := range c.syncer.peers {
+		if tp, ok := p.(timePeer); ok {
+			peers = append(peers, tp)
+		}
+	}
+
+	var samples []timeSample
+
+	for _, p := range peers {
+		s, err := sampleOffset(p)
+
+		if err != nil {
+			log.WithError(err).WithField("peer", p.NodeID()).Warn("time-sync probe failed")
+			continue
+		}
+
+		samples = append(samples, s)
+	}
+
+	if len(samples) == 0 {
+		c.rt.setSynced(false)
+		return
+	}
+
+	delays := make([]time.Duration, len(samples))
+
+	for i, s := range samples {
+		delays[i] = s.delay
+	}
+
+	medianDelay := medianDuration(delays)
+	var survivors []timeSample
+
+	for _, s := range samples {
+		if s.delay <= 2*medianDelay {
+			survivors = append(survivors, s)
+		}
+	}
+
+	// f is the maximum number of faulty/unreachable peers the BFT roster is expected to
+	// tolerate; we require agreement from at least f+1 peers before trusting the result.
+	f := (len(c.cfg.Peers.Servers) - 1) / 3
+	if len(survivors) < f+1 {
+		log.WithFields(log.Fields{"survivors": len(survivors), "required": f + 1}).
+			Warn("not enough peers agreed on chain time, refusing to sync")
+		c.rt.setSynced(false)
+		return
+	}
+
+	offsets := make([]time.Duration, len(survivors))
+
+	for i, s := range survivors {
+		offsets[i] = s.offset
+	}
+
+	newOffset := medianDuration(offsets)
+	skew := newOffset - c.rt.readOffset()
+
+	if skew < 0 {
+		skew = -skew
+	}
+
+	if skew > c.cfg.MaxAcceptableSkew {
+		log.WithFields(log.Fields{"skew": skew, "max": c.cfg.MaxAcceptableSkew}).
+			Warn("computed clock offset differs from current offset by more than MaxAcceptableSkew")
+		c.rt.setSynced(false)
+		return
+	}
+
+	c.rt.UpdateTime(time.Now().Add(newOffset))
+	c.rt.setSynced(true)
+}
+
+// timeSyncCycle runs syncTime every Period/2 until the chain is stopped, keeping Runtime.Offset
+// current without requiring a full block period to pass between corrections.
+func (c *Chain) timeSyncCycle() {
+	ticker := time.NewTicker(c.rt.Period / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.rt.stopCh:
+			return
+		case <-ticker.C:
+			c.syncTime()
+		}
+	}
+}